@@ -0,0 +1,1413 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIPathPrefix はJSON APIのパスの接頭辞
+const APIPathPrefix = "/api/v1"
+
+// apiErrorResponse はAPIのエラー時のレスポンス
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// apiError はAPIのエラー内容
+// Messagesには入力チェックのメッセージ(User, Post, Follow, BlockなどのMessages)が入る
+type apiError struct {
+	Status   int      `json:"status"`
+	Message  string   `json:"message"`
+	Messages []string `json:"messages,omitempty"`
+}
+
+// apiSweet はAPIで返すsweet
+type apiSweet struct {
+	ID         int64            `json:"id"`
+	UserID     int64            `json:"user_id"`
+	UserName   string           `json:"user_name"`
+	Message    string           `json:"message"`
+	InReplyTo  int64            `json:"in_reply_to,omitempty"`
+	ResweetOf  int64            `json:"resweet_of,omitempty"`
+	ReplyCount int64            `json:"reply_count"`
+	LikeCount  int64            `json:"like_count"`
+	LikedByMe  bool             `json:"liked_by_me"`
+	Depth      int              `json:"depth,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+	EditedAt   *time.Time       `json:"edited_at,omitempty"`
+	Deleted    bool             `json:"deleted,omitempty"`
+	Mentions   map[string]int64 `json:"mentions,omitempty"`
+	Tags       []string         `json:"tags,omitempty"`
+	Original   *apiSweet        `json:"original,omitempty"`
+}
+
+// apiThread はAPIで返すスレッド
+type apiThread struct {
+	Ancestors []apiSweet `json:"ancestors"`
+	Sweet     apiSweet   `json:"sweet"`
+	Replies   []apiSweet `json:"replies"`
+}
+
+// apiTimeline はAPIで返すタイムラインの1ページ
+// Older, Newerは前後のページを取得するためのbefore, afterパラメータ
+type apiTimeline struct {
+	Sweets []apiSweet `json:"sweets"`
+	Older  string     `json:"older,omitempty"`
+	Newer  string     `json:"newer,omitempty"`
+}
+
+// apiUser はAPIで返すユーザ
+type apiUser struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Email     string `json:"email,omitempty"`
+	Following *bool  `json:"following,omitempty"`
+	Protected *bool  `json:"protected,omitempty"`
+}
+
+// apiBlocks はAPIで返すブロック, ミュートしているユーザの一覧
+type apiBlocks struct {
+	Blocked []apiUser `json:"blocked"`
+	Muted   []apiUser `json:"muted"`
+}
+
+// apiNotification はAPIで返す通知
+type apiNotification struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	PostID    int64     `json:"post_id,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiNotifications はAPIで返す通知一覧の1ページ
+// UnreadCountは全体の未読の数
+type apiNotifications struct {
+	Notifications []apiNotification `json:"notifications"`
+	UnreadCount   int64             `json:"unread_count"`
+	Older         string            `json:"older,omitempty"`
+}
+
+// apiReadNotificationsRequest は通知を既読にするリクエスト
+// MaxIDが0なら全て既読にする
+type apiReadNotificationsRequest struct {
+	MaxID int64 `json:"max_id"`
+}
+
+// apiTag はAPIで返すトレンドのタグ
+type apiTag struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// apiFollowUsers はAPIで返すフォロー一覧, フォロワー一覧の1ページ
+type apiFollowUsers struct {
+	Count int64     `json:"count"`
+	Users []apiUser `json:"users"`
+	Older string    `json:"older,omitempty"`
+	Newer string    `json:"newer,omitempty"`
+}
+
+// apiAccessToken はAPIで返すアクセストークン
+// Tokenは発行直後のみ設定される
+type apiAccessToken struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Scope     string    `json:"scope"`
+	Token     string    `json:"token,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// apiTokenRequest はトークン発行のリクエスト
+type apiTokenRequest struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+}
+
+// apiRevokeTokenRequest はトークン失効のリクエスト
+type apiRevokeTokenRequest struct {
+	ID int64 `json:"id"`
+}
+
+// apiMeRequest はログインユーザ情報の変更のリクエスト
+// 指定しなかった項目は変更しない
+type apiMeRequest struct {
+	Protected *bool `json:"protected"`
+}
+
+// apiFollowRequest はfollow/unfollow, block/unblock, mute/unmute, フォローリクエストの承認/拒否のリクエスト
+type apiFollowRequest struct {
+	UserID int64 `json:"user_id"`
+}
+
+// apiSweetRequest はsweet投稿のリクエスト
+type apiSweetRequest struct {
+	Message   string `json:"message"`
+	InReplyTo int64  `json:"in_reply_to"`
+	ResweetOf int64  `json:"resweet_of"`
+}
+
+// apiEditSweetRequest はsweet編集のリクエスト
+type apiEditSweetRequest struct {
+	Message string `json:"message"`
+}
+
+// apiResweetRequest はリスイートとその取り消しのリクエスト
+type apiResweetRequest struct {
+	PostID int64 `json:"post_id"`
+}
+
+// apiLikeRequest はいいねとその取り消しのリクエスト
+type apiLikeRequest struct {
+	PostID int64 `json:"post_id"`
+}
+
+// PostをAPI用のsweetへ変換
+func newAPISweet(p Post) apiSweet {
+	sweet := apiSweet{
+		ID:         p.ID,
+		UserID:     p.UserID,
+		UserName:   p.UserName,
+		Message:    p.Message,
+		InReplyTo:  p.InReplyTo,
+		ResweetOf:  p.ResweetOf,
+		ReplyCount: p.ReplyCount,
+		LikeCount:  p.LikeCount,
+		LikedByMe:  p.LikedByMe,
+		Mentions:   p.Mentions,
+		Tags:       parseTags(p.Message),
+		Depth:      p.Depth,
+		CreatedAt:  p.CreatedAt,
+	}
+	if p.IsEdited() == true {
+		editedAt := p.EditedAt
+		sweet.EditedAt = &editedAt
+	}
+	// 削除済のsweetは内容を返さない
+	if p.Deleted == true {
+		sweet.Message = ""
+		sweet.EditedAt = nil
+		sweet.Mentions = nil
+		sweet.Tags = nil
+		sweet.Deleted = true
+	}
+	if p.Original != nil {
+		original := newAPISweet(*p.Original)
+		sweet.Original = &original
+	}
+	return sweet
+}
+
+// NotificationのスライスをAPI用の通知のスライスへ変換
+func newAPINotifications(notifications []Notification) []apiNotification {
+	res := make([]apiNotification, 0, len(notifications))
+	for _, n := range notifications {
+		res = append(res, apiNotification{
+			ID:        n.ID,
+			Type:      n.Type,
+			UserID:    n.ActorID,
+			UserName:  n.ActorName,
+			PostID:    n.PostID,
+			Message:   n.Message,
+			Read:      n.Read,
+			CreatedAt: n.CreatedAt,
+		})
+	}
+	return res
+}
+
+// Userのスライスをメールアドレスを除いたAPI用のユーザのスライスへ変換
+func newAPIUsers(users []User) []apiUser {
+	res := make([]apiUser, 0, len(users))
+	for _, u := range users {
+		res = append(res, apiUser{ID: u.ID, Name: u.Name})
+	}
+	return res
+}
+
+// FollowUserのスライスをAPI用のユーザのスライスへ変換
+func newAPIFollowUsers(users []FollowUser) []apiUser {
+	res := make([]apiUser, 0, len(users))
+	for _, u := range users {
+		following := u.Following
+		res = append(res, apiUser{ID: u.ID, Name: u.Name, Following: &following})
+	}
+	return res
+}
+
+// PostのスライスをAPI用のsweetのスライスへ変換
+func newAPISweets(posts []Post) []apiSweet {
+	sweets := make([]apiSweet, 0, len(posts))
+	for _, p := range posts {
+		sweets = append(sweets, newAPISweet(p))
+	}
+	return sweets
+}
+
+// AccessTokenをAPI用のトークンへ変換
+func newAPIAccessToken(t AccessToken) apiAccessToken {
+	return apiAccessToken{
+		ID:        t.ID,
+		Name:      t.Name,
+		Scope:     t.Scope,
+		Token:     t.Token,
+		CreatedAt: t.CreatedAt,
+	}
+}
+
+// vをJSONにしてstatusで返す
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// エラーをJSONで返す
+func writeJSONError(w http.ResponseWriter, status int, messages []string) {
+	writeJSON(w, status, &apiErrorResponse{
+		Error: apiError{
+			Status:   status,
+			Message:  http.StatusText(status),
+			Messages: messages,
+		},
+	})
+}
+
+// リクエストボディのJSONをvへ読み込む
+func readJSON(r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// セッションから認証したユーザのIDを取得
+func sessionUserID(s *Session) (int64, error) {
+	uidv, err := s.Get(SessionUserIDKey)
+	if err != nil {
+		return 0, err
+	}
+	uid, ok := uidv.(int64)
+	if ok == false {
+		return 0, errors.New("user_id type assertion fail")
+	}
+	return uid, nil
+}
+
+// API用の認証処理
+// 認証出来なければリダイレクトではなく401を返す
+func (app *App) needAPILogin(fn HandlerFuncWithSession) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, s, err := app.loginSession(w, r); ok == true {
+			// トークンのスコープ外の操作は拒否
+			if tokenScopeAllows(s, r) == false {
+				writeJSONError(w, http.StatusForbidden, []string{"トークンのスコープでは許可されていない操作です"})
+				return
+			}
+			fn(w, r, s)
+		} else {
+			// errorがあればロギング
+			if err != nil {
+				log.Println(err)
+			}
+			writeJSONError(w, http.StatusUnauthorized, nil)
+		}
+	}
+}
+
+// [/api/v1/me]処理用のハンドラ
+func (app *App) apiMeHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GETとPUT以外は許可しない
+	if r.Method != "GET" && r.Method != "PUT" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// 設定の変更
+	if r.Method == "PUT" {
+		var req apiMeRequest
+		if err := readJSON(r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, []string{"リクエストの形式が不正です"})
+			return
+		}
+		if req.Protected != nil {
+			if err := app.setProtected(uid, *req.Protected); err != nil {
+				log.Println(err)
+				writeJSONError(w, http.StatusInternalServerError, nil)
+				return
+			}
+		}
+	}
+	// ユーザ情報の取得
+	u := &User{}
+	exist, err := app.Users.FindByID(u, uid)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if exist == false {
+		writeJSONError(w, http.StatusNotFound, nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, &apiUser{ID: u.ID, Name: u.Name, Email: u.Email, Protected: &u.Protected})
+}
+
+// [/api/v1/timeline]処理用のハンドラ
+func (app *App) apiTimelineHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は許可しない
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.URL.Query().Get("before"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"beforeの形式が不正です"})
+		return
+	}
+	after, err := parseTimelineCursor(r.URL.Query().Get("after"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"afterの形式が不正です"})
+		return
+	}
+	// sweetsの取得
+	posts, older, newer, err := app.Posts.TimelinePage(uid, app.Config.TimelinePageLimit, before, after)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	res := &apiTimeline{Sweets: newAPISweets(posts)}
+	if older != nil {
+		res.Older = older.String()
+	}
+	if newer != nil {
+		res.Newer = newer.String()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// [/api/v1/mentions]処理用のハンドラ
+func (app *App) apiMentionsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は許可しない
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.URL.Query().Get("before"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"beforeの形式が不正です"})
+		return
+	}
+	after, err := parseTimelineCursor(r.URL.Query().Get("after"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"afterの形式が不正です"})
+		return
+	}
+	// メンションされたsweetsの取得
+	posts, older, newer, err := app.MentionsPage(uid, app.Config.TimelinePageLimit, before, after)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if err := app.fillLikedByMe(uid, posts); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	res := &apiTimeline{Sweets: newAPISweets(posts)}
+	if older != nil {
+		res.Older = older.String()
+	}
+	if newer != nil {
+		res.Newer = newer.String()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// [/api/v1/tags/{tag}]処理用のハンドラ
+func (app *App) apiTagHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は許可しない
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	name, ok := parseTagPath(strings.TrimPrefix(r.URL.Path, APIPathPrefix))
+	if ok == false {
+		writeJSONError(w, http.StatusNotFound, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.URL.Query().Get("before"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"beforeの形式が不正です"})
+		return
+	}
+	after, err := parseTimelineCursor(r.URL.Query().Get("after"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"afterの形式が不正です"})
+		return
+	}
+	// タグの付いたsweetsの取得
+	posts, older, newer, err := app.TagSweetsPage(name, uid, app.Config.TimelinePageLimit, before, after)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if err := app.fillLikedByMe(uid, posts); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	res := &apiTimeline{Sweets: newAPISweets(posts)}
+	if older != nil {
+		res.Older = older.String()
+	}
+	if newer != nil {
+		res.Newer = newer.String()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// [/api/v1/trends]処理用のハンドラ
+// hoursで集計する期間を時間単位で指定する
+func (app *App) apiTrendsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は許可しない
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 集計期間を取得
+	period := TrendingTagsPeriod
+	if v := r.URL.Query().Get("hours"); v != "" {
+		hours, err := strconv.Atoi(v)
+		if err != nil || hours < 1 || 24*7 < hours {
+			writeJSONError(w, http.StatusBadRequest, []string{"hoursは1から168の整数で指定してください"})
+			return
+		}
+		period = time.Duration(hours) * time.Hour
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// トレンドの取得
+	tags, err := app.TrendingTags(uid, time.Now().Add(-period), TrendingTagsLimit)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	res := make([]apiTag, 0, len(tags))
+	for _, t := range tags {
+		res = append(res, apiTag{Name: t.Name, Count: t.Count})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// [/api/v1/search]処理用のハンドラ
+// 古い側のページのみ辿れる
+func (app *App) apiSearchHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は許可しない
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.URL.Query().Get("before"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"beforeの形式が不正です"})
+		return
+	}
+	// 入力チェック
+	q := parseSearchQuery(r.URL.Query().Get("q"))
+	if err := q.Validate(); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if len(q.Messages) > 0 {
+		writeJSONError(w, http.StatusUnprocessableEntity, q.Messages)
+		return
+	}
+	// 検索
+	posts, older, err := app.SearchPage(q, uid, app.Config.TimelinePageLimit, before)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if err := app.fillLikedByMe(uid, posts); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	res := &apiTimeline{Sweets: newAPISweets(posts)}
+	if older != nil {
+		res.Older = older.String()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// [/api/v1/sweets]処理用のハンドラ
+func (app *App) apiSweetsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は許可しない
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// リクエストを読み込んで投稿データを作成
+	var req apiSweetRequest
+	if err := readJSON(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"リクエストの形式が不正です"})
+		return
+	}
+	post := &Post{
+		UserID:    uid,
+		Message:   req.Message,
+		InReplyTo: req.InReplyTo,
+		ResweetOf: req.ResweetOf,
+	}
+	// 入力チェック
+	if err := post.Validate(app.Store); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if len(post.Messages) > 0 {
+		writeJSONError(w, http.StatusUnprocessableEntity, post.Messages)
+		return
+	}
+	// 登録
+	if err := app.Posts.Entry(post); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newAPISweet(*post))
+}
+
+// [/api/v1/sweets/{id}]処理用のハンドラ
+// GETはスレッドの取得, PUTは編集, DELETEは削除
+func (app *App) apiSweetHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	id, sub, ok := parseSweetPath(strings.TrimPrefix(r.URL.Path, APIPathPrefix))
+	if ok == false || sub != "" {
+		writeJSONError(w, http.StatusNotFound, nil)
+		return
+	}
+
+	// 削除
+	// 他のユーザのsweetや削除済のsweetは存在しないものとして扱う
+	if r.Method == "DELETE" {
+		p := &Post{ID: id}
+		removed, err := app.Posts.Remove(p, uid)
+		if err != nil {
+			log.Println(err)
+			writeJSONError(w, http.StatusInternalServerError, nil)
+			return
+		}
+		if removed == false {
+			writeJSONError(w, http.StatusNotFound, nil)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != "GET" && r.Method != "PUT" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	// スレッドの取得
+	t, exist, err := app.findThread(id)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if exist == false {
+		writeJSONError(w, http.StatusNotFound, nil)
+		return
+	}
+
+	// 編集
+	if r.Method == "PUT" {
+		var req apiEditSweetRequest
+		if err := readJSON(r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, []string{"リクエストの形式が不正です"})
+			return
+		}
+		// 入力チェック
+		if err := t.Post.ValidateEdit(uid, req.Message); err != nil {
+			log.Println(err)
+			writeJSONError(w, http.StatusInternalServerError, nil)
+			return
+		}
+		if len(t.Post.Messages) > 0 {
+			writeJSONError(w, http.StatusUnprocessableEntity, t.Post.Messages)
+			return
+		}
+		if err := app.Posts.Edit(&t.Post, req.Message); err != nil {
+			log.Println(err)
+			writeJSONError(w, http.StatusInternalServerError, nil)
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPISweet(t.Post))
+		return
+	}
+
+	// ブロック関係にあるユーザや鍵アカウントのsweetは返さない
+	visible, err := t.hideInvisible(app.Store, uid)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if visible == false {
+		writeJSONError(w, http.StatusNotFound, nil)
+		return
+	}
+	if err := t.fillLikedByMe(app.Store, uid); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, &apiThread{
+		Ancestors: newAPISweets(t.Ancestors),
+		Sweet:     newAPISweet(t.Post),
+		Replies:   newAPISweets(t.Replies),
+	})
+}
+
+// [/api/v1/users]処理用のハンドラ
+func (app *App) apiUsersHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は許可しない
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// ユーザ一覧を取得
+	users, err := app.Followers.Search(uid, r.URL.Query().Get("q"), app.Config.UserSearchPageLimit, 0)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIFollowUsers(users))
+}
+
+// [/api/v1/users/{id}/following]と[/api/v1/users/{id}/followers]処理用のハンドラ
+func (app *App) apiUserHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は許可しない
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	id, sub, ok := parseUserPath(strings.TrimPrefix(r.URL.Path, APIPathPrefix))
+	if ok == false || (sub != "following" && sub != "followers") {
+		writeJSONError(w, http.StatusNotFound, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.URL.Query().Get("before"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"beforeの形式が不正です"})
+		return
+	}
+	after, err := parseTimelineCursor(r.URL.Query().Get("after"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"afterの形式が不正です"})
+		return
+	}
+	// ユーザの存在チェック
+	exist, err := app.Users.Exists(id)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if exist == false {
+		writeJSONError(w, http.StatusNotFound, nil)
+		return
+	}
+	// 人数と一覧の取得
+	followers := sub == "followers"
+	followingCount, followerCount, err := app.Followers.Count(id)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	users, older, newer, err := app.Followers.Page(id, followers, uid, app.Config.UserSearchPageLimit, before, after)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	res := &apiFollowUsers{Count: followingCount, Users: newAPIFollowUsers(users)}
+	if followers == true {
+		res.Count = followerCount
+	}
+	if older != nil {
+		res.Older = older.String()
+	}
+	if newer != nil {
+		res.Newer = newer.String()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// [/api/v1/follow]処理用のハンドラ
+func (app *App) apiFollowHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は許可しない
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// フォローするユーザを取得
+	var req apiFollowRequest
+	if err := readJSON(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"リクエストの形式が不正です"})
+		return
+	}
+	// 登録前チェック
+	f := Follow{FollowerID: uid, FolloweeID: req.UserID}
+	if err := f.Validate(app.Store); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if len(f.Messages) > 0 {
+		writeJSONError(w, http.StatusUnprocessableEntity, f.Messages)
+		return
+	}
+	// フォロー情報を登録
+	if err := app.Followers.Entry(&f); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// 鍵アカウントへはフォローリクエストになる
+	if f.Requested == true {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// [/api/v1/unfollow]処理用のハンドラ
+func (app *App) apiUnfollowHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は許可しない
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// フォロー解除するユーザを取得
+	var req apiFollowRequest
+	if err := readJSON(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"リクエストの形式が不正です"})
+		return
+	}
+	// フォロー情報を削除
+	f := Follow{FollowerID: uid, FolloweeID: req.UserID}
+	if err := app.Followers.Remove(&f); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// [/api/v1/notifications]処理用のハンドラ
+// 取得しただけでは既読にしない
+func (app *App) apiNotificationsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は許可しない
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.URL.Query().Get("before"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"beforeの形式が不正です"})
+		return
+	}
+	// 通知の取得
+	notifications, older, err := app.NotificationsPage(uid, NotificationPageLimit, before)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	unread, err := app.countUnreadNotifications(uid)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	res := &apiNotifications{Notifications: newAPINotifications(notifications), UnreadCount: unread}
+	if older != nil {
+		res.Older = older.String()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// [/api/v1/notifications/read]処理用のハンドラ
+func (app *App) apiReadNotificationsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は許可しない
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// 既読にする範囲を取得
+	var req apiReadNotificationsRequest
+	if err := readJSON(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"リクエストの形式が不正です"})
+		return
+	}
+	if err := app.markNotificationsRead(uid, req.MaxID); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// [/api/v1/follow_requests]処理用のハンドラ
+func (app *App) apiFollowRequestsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は許可しない
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	users, err := app.findFollowRequesters(uid)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIUsers(users))
+}
+
+// [/api/v1/follow_requests/approve]処理用のハンドラ
+func (app *App) apiApproveFollowRequestHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	uid, requesterID, ok := apiUserTargetFromRequest(w, r, s)
+	if ok == false {
+		return
+	}
+	// 承認
+	fr := &FollowRequest{RequesterID: requesterID, TargetID: uid}
+	approved, err := fr.Approve(app.Store)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if approved == false {
+		writeJSONError(w, http.StatusNotFound, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// [/api/v1/follow_requests/reject]処理用のハンドラ
+func (app *App) apiRejectFollowRequestHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	uid, requesterID, ok := apiUserTargetFromRequest(w, r, s)
+	if ok == false {
+		return
+	}
+	// 拒否
+	fr := &FollowRequest{RequesterID: requesterID, TargetID: uid}
+	rejected, err := fr.Reject(app.Store)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if rejected == false {
+		writeJSONError(w, http.StatusNotFound, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// [/api/v1/blocks]処理用のハンドラ
+func (app *App) apiBlocksHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は許可しない
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+
+	blocked, err := app.findBlockedUsers(uid)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	muted, err := app.findMutedUsers(uid)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, &apiBlocks{Blocked: newAPIUsers(blocked), Muted: newAPIUsers(muted)})
+}
+
+// apiBlockHandlerなどで対象のユーザIDと認証したユーザのIDを取得する
+// 取得できなければエラーを返してfalseを返す
+func apiUserTargetFromRequest(w http.ResponseWriter, r *http.Request, s *Session) (int64, int64, bool) {
+	// POST以外は許可しない
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return 0, 0, false
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return 0, 0, false
+	}
+	// 対象のユーザを取得
+	var req apiFollowRequest
+	if err := readJSON(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"リクエストの形式が不正です"})
+		return 0, 0, false
+	}
+	return uid, req.UserID, true
+}
+
+// [/api/v1/block]処理用のハンドラ
+func (app *App) apiBlockHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	uid, targetID, ok := apiUserTargetFromRequest(w, r, s)
+	if ok == false {
+		return
+	}
+	// 登録前チェック
+	b := &Block{UserID: uid, TargetID: targetID}
+	if err := b.Validate(app.Store); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if len(b.Messages) > 0 {
+		writeJSONError(w, http.StatusUnprocessableEntity, b.Messages)
+		return
+	}
+	// ブロック情報を登録
+	if err := b.Entry(app.Store); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// [/api/v1/unblock]処理用のハンドラ
+func (app *App) apiUnblockHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	uid, targetID, ok := apiUserTargetFromRequest(w, r, s)
+	if ok == false {
+		return
+	}
+	// ブロック情報を削除
+	b := &Block{UserID: uid, TargetID: targetID}
+	if err := b.Remove(app.Store); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// [/api/v1/mute]処理用のハンドラ
+func (app *App) apiMuteHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	uid, targetID, ok := apiUserTargetFromRequest(w, r, s)
+	if ok == false {
+		return
+	}
+	// 登録前チェック
+	m := &Mute{UserID: uid, TargetID: targetID}
+	if err := m.Validate(app.Store); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if len(m.Messages) > 0 {
+		writeJSONError(w, http.StatusUnprocessableEntity, m.Messages)
+		return
+	}
+	// ミュート情報を登録
+	if err := m.Entry(app.Store); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// [/api/v1/unmute]処理用のハンドラ
+func (app *App) apiUnmuteHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	uid, targetID, ok := apiUserTargetFromRequest(w, r, s)
+	if ok == false {
+		return
+	}
+	// ミュート情報を削除
+	m := &Mute{UserID: uid, TargetID: targetID}
+	if err := m.Remove(app.Store); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// [/api/v1/resweet]処理用のハンドラ
+func (app *App) apiResweetHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は許可しない
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// リスイートするsweetを取得
+	var req apiResweetRequest
+	if err := readJSON(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"リクエストの形式が不正です"})
+		return
+	}
+	// 入力チェック
+	post := &Post{UserID: uid, ResweetOf: req.PostID}
+	if err := post.Validate(app.Store); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if len(post.Messages) > 0 {
+		writeJSONError(w, http.StatusUnprocessableEntity, post.Messages)
+		return
+	}
+	// 登録
+	if err := app.Posts.Entry(post); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newAPISweet(*post))
+}
+
+// [/api/v1/unresweet]処理用のハンドラ
+func (app *App) apiUnresweetHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は許可しない
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// リスイートを取り消すsweetを取得
+	var req apiResweetRequest
+	if err := readJSON(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"リクエストの形式が不正です"})
+		return
+	}
+	// リスイートを削除
+	if err := app.Posts.RemoveResweet(uid, req.PostID); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// [/api/v1/like]処理用のハンドラ
+func (app *App) apiLikeHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は許可しない
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// いいねするsweetを取得
+	var req apiLikeRequest
+	if err := readJSON(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"リクエストの形式が不正です"})
+		return
+	}
+	// 入力チェック
+	l := &Like{UserID: uid, PostID: req.PostID}
+	if err := l.Validate(app.Store); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	if len(l.Messages) > 0 {
+		writeJSONError(w, http.StatusUnprocessableEntity, l.Messages)
+		return
+	}
+	// 登録
+	if err := l.Entry(app.Store); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// [/api/v1/unlike]処理用のハンドラ
+func (app *App) apiUnlikeHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は許可しない
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// いいねを取り消すsweetを取得
+	var req apiLikeRequest
+	if err := readJSON(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"リクエストの形式が不正です"})
+		return
+	}
+	// いいねを削除
+	l := &Like{UserID: uid, PostID: req.PostID}
+	if err := l.Remove(app.Store); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// [/api/v1/tokens]処理用のハンドラ
+func (app *App) apiTokensHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// トークンによるトークン管理は許可しない
+	if isTokenSession(s) == true {
+		writeJSONError(w, http.StatusForbidden, []string{"トークンの管理はログインセッションで行ってください"})
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		// トークン一覧の取得
+		tokens, err := app.findAccessTokensByUserID(uid)
+		if err != nil {
+			log.Println(err)
+			writeJSONError(w, http.StatusInternalServerError, nil)
+			return
+		}
+		res := make([]apiAccessToken, 0, len(tokens))
+		for _, t := range tokens {
+			res = append(res, newAPIAccessToken(t))
+		}
+		writeJSON(w, http.StatusOK, res)
+	case "POST":
+		var req apiTokenRequest
+		if err := readJSON(r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, []string{"リクエストの形式が不正です"})
+			return
+		}
+		// 入力チェック
+		t := &AccessToken{UserID: uid, Name: req.Name, Scope: req.Scope}
+		if err := t.Validate(); err != nil {
+			log.Println(err)
+			writeJSONError(w, http.StatusInternalServerError, nil)
+			return
+		}
+		if len(t.Messages) > 0 {
+			writeJSONError(w, http.StatusUnprocessableEntity, t.Messages)
+			return
+		}
+		// 発行
+		if err := t.Entry(app.Store); err != nil {
+			log.Println(err)
+			writeJSONError(w, http.StatusInternalServerError, nil)
+			return
+		}
+		writeJSON(w, http.StatusCreated, newAPIAccessToken(*t))
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// [/api/v1/tokens/revoke]処理用のハンドラ
+func (app *App) apiRevokeTokenHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は許可しない
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// トークンによるトークン管理は許可しない
+	if isTokenSession(s) == true {
+		writeJSONError(w, http.StatusForbidden, []string{"トークンの管理はログインセッションで行ってください"})
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	var req apiRevokeTokenRequest
+	if err := readJSON(r, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, []string{"リクエストの形式が不正です"})
+		return
+	}
+	// 失効
+	t := &AccessToken{ID: req.ID, UserID: uid}
+	if err := t.Remove(app.Store); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
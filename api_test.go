@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakePostRepository はタイムラインを決まったsweetに差し替えたPostRepository
+// それ以外はPostRepositoryへ委譲する
+type fakePostRepository struct {
+	PostRepository
+	timeline []Post
+}
+
+// TimelinePage はtimelineをそのまま返す
+func (fr *fakePostRepository) TimelinePage(userID int64, limit int, before *TimelineCursor, after *TimelineCursor) ([]Post, *TimelineCursor, *TimelineCursor, error) {
+	var older *TimelineCursor
+	if len(fr.timeline) > 0 {
+		older = fr.timeline[len(fr.timeline)-1].Cursor()
+	}
+	return fr.timeline, older, nil, nil
+}
+
+// fakeUserRepository はExistsの結果を差し替えたUserRepository
+type fakeUserRepository struct {
+	UserRepository
+	exists map[int64]bool
+}
+
+// Exists はexistsに登録したユーザであればtrueを返す
+func (fr *fakeUserRepository) Exists(id int64) (bool, error) {
+	return fr.exists[id], nil
+}
+
+// userIDでログインしたセッションを返す
+func newTestSession(userID int64) *Session {
+	return &Session{data: map[interface{}]interface{}{SessionUserIDKey: userID}}
+}
+
+func TestAPITimelineHandlerWithFakeRepository(t *testing.T) {
+	st := newTestStore(t)
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 6000, time.UTC)
+	st.Posts = &fakePostRepository{
+		PostRepository: st.Posts,
+		timeline:       []Post{{ID: 42, UserID: 7, UserName: "fake", Message: "DBにないsweet", CreatedAt: createdAt}},
+	}
+	app := &App{Store: st, Config: defaultConfig()}
+
+	w := httptest.NewRecorder()
+	app.apiTimelineHandler(w, httptest.NewRequest("GET", "/api/v1/timeline", nil), newTestSession(1))
+	if w.Code != 200 {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	var res apiTimeline
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Sweets) != 1 || res.Sweets[0].ID != 42 || res.Sweets[0].UserName != "fake" {
+		t.Errorf("sweets = %+v", res.Sweets)
+	}
+	if want := (&TimelineCursor{CreatedAt: createdAt, ID: 42}).String(); res.Older != want {
+		t.Errorf("older = %q, want %q", res.Older, want)
+	}
+}
+
+func TestPostValidateWithFakeRepository(t *testing.T) {
+	st := newTestStore(t)
+	st.Users = &fakeUserRepository{UserRepository: st.Users, exists: map[int64]bool{1: true}}
+
+	// DBにユーザがいなくてもリポジトリの結果で判定する
+	p := &Post{UserID: 1, Message: "こんにちは"}
+	if err := p.Validate(st); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Messages) != 0 {
+		t.Errorf("Validate(existing user) = %q", p.Messages)
+	}
+	p = &Post{UserID: 2, Message: "こんにちは"}
+	if err := p.Validate(st); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Messages) != 1 {
+		t.Errorf("Validate(unknown user) = %q", p.Messages)
+	}
+}
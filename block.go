@@ -0,0 +1,480 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Block はユーザのブロック
+// ブロックするとお互いのフォローとフォローリクエストが解除され, お互いのsweetが表示されなくなる
+type Block struct {
+	UserID   int64 // ブロックするユーザのID
+	TargetID int64 // ブロックされるユーザのID
+	Messages []string
+}
+
+// Mute はユーザのミュート
+// ミュートするとフォローしたまま相手のsweetがタイムラインに表示されなくなる
+type Mute struct {
+	UserID   int64 // ミュートするユーザのID
+	TargetID int64 // ミュートされるユーザのID
+	Messages []string
+}
+
+// BlocksForTemplate はブロック, ミュート管理画面用のデータ構造
+type BlocksForTemplate struct {
+	Blocked []User
+	Muted   []User
+}
+
+// ブロック, ミュートする相手のチェック
+func (st *Store) validateBlockTarget(userID int64, targetID int64) ([]string, error) {
+	var messages []string
+
+	// 自分自身は対象にしない
+	if userID == targetID {
+		messages = append(messages, "自分自身は指定出来ません")
+	} else if exist, err := st.Users.Exists(targetID); err != nil {
+		return nil, err
+	} else if exist == false {
+		messages = append(messages, "指定したユーザは存在しません")
+	}
+	return messages, nil
+}
+
+// Validate はBlockの登録前の入力チェックを行う
+func (b *Block) Validate(st *Store) error {
+	messages, err := st.validateBlockTarget(b.UserID, b.TargetID)
+	if err != nil {
+		return err
+	}
+	b.Messages = messages
+	return nil
+}
+
+// Entry はBlockの情報登録を行う
+// お互いのフォローとフォローリクエストも解除する. 既にブロックしている場合は何もしない
+func (b *Block) Entry(st *Store) error {
+	db := st.db
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 登録
+	_, err = tx.Exec(tx.dialect.InsertIgnore("INSERT INTO blocks(user_id, blocked_user_id, created_at) VALUES(?, ?, ?)"), b.UserID, b.TargetID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// お互いのフォローを解除
+	_, err = tx.Exec(`
+		DELETE
+		FROM
+			followers
+		WHERE
+			(follower_user_id = ? AND followee_user_id = ?)
+		OR
+			(follower_user_id = ? AND followee_user_id = ?)
+	`, b.UserID, b.TargetID, b.TargetID, b.UserID)
+	if err != nil {
+		return err
+	}
+
+	// お互いのフォローリクエストを取り消し
+	_, err = tx.Exec(`
+		DELETE
+		FROM
+			follow_requests
+		WHERE
+			(requester_user_id = ? AND target_user_id = ?)
+		OR
+			(requester_user_id = ? AND target_user_id = ?)
+	`, b.UserID, b.TargetID, b.TargetID, b.UserID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Remove はBlockの情報削除を行う
+// 解除したフォローは元に戻さない
+func (b *Block) Remove(st *Store) error {
+	db := st.db
+
+	// プリペアードステートメント生成
+	stmt, err := db.Prepare(`
+		DELETE
+		FROM
+			blocks
+		WHERE
+			user_id = ?
+		AND
+			blocked_user_id = ?
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// クエリ発行
+	_, err = stmt.Exec(b.UserID, b.TargetID)
+	return err
+}
+
+// Validate はMuteの登録前の入力チェックを行う
+func (m *Mute) Validate(st *Store) error {
+	messages, err := st.validateBlockTarget(m.UserID, m.TargetID)
+	if err != nil {
+		return err
+	}
+	m.Messages = messages
+	return nil
+}
+
+// Entry はMuteの情報登録を行う
+// 既にミュートしている場合は何もしない
+func (m *Mute) Entry(st *Store) error {
+	db := st.db
+
+	// プリペアードステートメント生成
+	stmt, err := db.Prepare(db.dialect.InsertIgnore("INSERT INTO mutes(user_id, muted_user_id, created_at) VALUES(?, ?, ?)"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// クエリ発行
+	_, err = stmt.Exec(m.UserID, m.TargetID, time.Now())
+	return err
+}
+
+// Remove はMuteの情報削除を行う
+func (m *Mute) Remove(st *Store) error {
+	db := st.db
+
+	// プリペアードステートメント生成
+	stmt, err := db.Prepare(`
+		DELETE
+		FROM
+			mutes
+		WHERE
+			user_id = ?
+		AND
+			muted_user_id = ?
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// クエリ発行
+	_, err = stmt.Exec(m.UserID, m.TargetID)
+	return err
+}
+
+// isBlocking はuserIDがtargetIDをブロックしていればtrueを返す
+func (st *Store) isBlocking(userID int64, targetID int64) (bool, error) {
+	db := st.db
+
+	// クエリ発行
+	var count int64
+	err := db.QueryRow(`
+	SELECT
+		COUNT(*)
+	FROM
+		blocks b
+	WHERE
+		b.user_id = ?
+	AND
+		b.blocked_user_id = ?
+	`, userID, targetID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// isBlockedEither はuserIDとtargetIDのどちらかが相手をブロックしていればtrueを返す
+func (st *Store) isBlockedEither(userID int64, targetID int64) (bool, error) {
+	blocking, err := st.isBlocking(userID, targetID)
+	if err != nil || blocking == true {
+		return blocking, err
+	}
+	return st.isBlocking(targetID, userID)
+}
+
+// isMuting はuserIDがtargetIDをミュートしていればtrueを返す
+func (st *Store) isMuting(userID int64, targetID int64) (bool, error) {
+	db := st.db
+
+	// クエリ発行
+	var count int64
+	err := db.QueryRow(`
+	SELECT
+		COUNT(*)
+	FROM
+		mutes m
+	WHERE
+		m.user_id = ?
+	AND
+		m.muted_user_id = ?
+	`, userID, targetID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// userIDがブロックしているユーザとuserIDをブロックしているユーザのIDを返す
+func (st *Store) blockedUserIDs(userID int64) (map[int64]bool, error) {
+	db := st.db
+	// SQL発行
+	rows, err := db.Query(`
+		SELECT
+			b.blocked_user_id
+		FROM
+			blocks b
+		WHERE
+			b.user_id = ?
+		UNION
+		SELECT
+			b.user_id
+		FROM
+			blocks b
+		WHERE
+			b.blocked_user_id = ?
+	`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// hideBlocked はpostsからuserIDとブロック関係にあるユーザのPostを除いて返す
+// リスイート元の投稿者も対象にする
+func (st *Store) hideBlocked(userID int64, posts []Post) ([]Post, error) {
+	blocked, err := st.blockedUserIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocked) == 0 {
+		return posts, nil
+	}
+	visible := make([]Post, 0, len(posts))
+	for _, p := range posts {
+		if blocked[p.UserID] == true || (p.Original != nil && blocked[p.Original.UserID] == true) {
+			continue
+		}
+		visible = append(visible, p)
+	}
+	return visible, nil
+}
+
+// blocksかmutesでuserIDが対象にしているユーザ一覧を新しい順に返す
+// tableとcolumnはプログラム中の定数のみ渡す
+func (st *Store) findBlockTargets(userID int64, table string, column string) ([]User, error) {
+	db := st.db
+	// SQL発行
+	rows, err := db.Query(`
+		SELECT
+			u.id,
+			u.name
+		FROM
+			`+table+` t
+		INNER JOIN
+			users u
+		ON
+			u.id = t.`+column+`
+		WHERE
+			t.user_id = ?
+		ORDER BY
+			t.created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Name); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// findBlockedUsers はuserIDがブロックしているユーザ一覧を返す
+func (st *Store) findBlockedUsers(userID int64) ([]User, error) {
+	return st.findBlockTargets(userID, "blocks", "blocked_user_id")
+}
+
+// findMutedUsers はuserIDがミュートしているユーザ一覧を返す
+func (st *Store) findMutedUsers(userID int64) ([]User, error) {
+	return st.findBlockTargets(userID, "mutes", "muted_user_id")
+}
+
+// [/blocks]のハンドラ
+func (app *App) blocksHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は存在しない
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	bft := &BlocksForTemplate{}
+	bft.Blocked, err = app.findBlockedUsers(uid)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	bft.Muted, err = app.findMutedUsers(uid)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	err = responseTemplate.ExecuteTemplate(w, "blocks.tmpl", bft)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+	}
+}
+
+// blockHandlerなどで対象のユーザIDと認証したユーザのIDを取得する
+// 取得できなければエラーを返してfalseを返す
+func userTargetFromRequest(w http.ResponseWriter, r *http.Request, s *Session) (int64, int64, bool) {
+	// POST以外は存在しない
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return 0, 0, false
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return 0, 0, false
+	}
+	// 対象のユーザを取得
+	targetID, err := strconv.ParseInt(r.FormValue("user_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return uid, targetID, true
+}
+
+// [/block]のハンドラ
+func (app *App) blockHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	uid, targetID, ok := userTargetFromRequest(w, r, s)
+	if ok == false {
+		return
+	}
+	// 入力チェック
+	b := &Block{UserID: uid, TargetID: targetID}
+	if err := b.Validate(app.Store); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if len(b.Messages) > 0 {
+		http.NotFound(w, r)
+		return
+	}
+	// 登録
+	if err := b.Entry(app.Store); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	// 指定がなければ管理画面へ回す
+	redirectBack(w, r, "/blocks")
+}
+
+// [/unblock]のハンドラ
+func (app *App) unblockHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	uid, targetID, ok := userTargetFromRequest(w, r, s)
+	if ok == false {
+		return
+	}
+	// 削除
+	b := &Block{UserID: uid, TargetID: targetID}
+	if err := b.Remove(app.Store); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	// 指定がなければ管理画面へ回す
+	redirectBack(w, r, "/blocks")
+}
+
+// [/mute]のハンドラ
+func (app *App) muteHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	uid, targetID, ok := userTargetFromRequest(w, r, s)
+	if ok == false {
+		return
+	}
+	// 入力チェック
+	m := &Mute{UserID: uid, TargetID: targetID}
+	if err := m.Validate(app.Store); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if len(m.Messages) > 0 {
+		http.NotFound(w, r)
+		return
+	}
+	// 登録
+	if err := m.Entry(app.Store); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	// 指定がなければ管理画面へ回す
+	redirectBack(w, r, "/blocks")
+}
+
+// [/unmute]のハンドラ
+func (app *App) unmuteHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	uid, targetID, ok := userTargetFromRequest(w, r, s)
+	if ok == false {
+		return
+	}
+	// 削除
+	m := &Mute{UserID: uid, TargetID: targetID}
+	if err := m.Remove(app.Store); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	// 指定がなければ管理画面へ回す
+	redirectBack(w, r, "/blocks")
+}
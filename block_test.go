@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// ブロックしたユーザのsweetはLIMITの前に除くので, ページが欠けたり空になったりしない
+func TestBlockedSweetsArePagedInSQL(t *testing.T) {
+	st := newTestStore(t)
+	alice := newTestUser(t, st, "alice")
+	bob := newTestUser(t, st, "bob")
+	carol := newTestUser(t, st, "carol")
+
+	// carolの2件より新しいbobの3件と, それをリスイートしたcarolの3件
+	c1 := newTestPost(t, st, carol.ID, "@alice 検索語 #tag 1")
+	c2 := newTestPost(t, st, carol.ID, "@alice 検索語 #tag 2")
+	for i := 0; i < 3; i++ {
+		b := newTestPost(t, st, bob.ID, "@alice 検索語 #tag")
+		if err := st.Posts.Entry(&Post{UserID: carol.ID, ResweetOf: b.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := (&Block{UserID: alice.ID, TargetID: bob.ID}).Entry(st); err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{c2.ID, c1.ID}
+
+	check := func(name string, posts []Post, older *TimelineCursor) {
+		t.Helper()
+		if got := postIDs(posts); reflect.DeepEqual(got, want) == false {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+		if older != nil {
+			t.Errorf("%s has older page %s", name, older)
+		}
+	}
+
+	posts, older, _, err := st.TagSweetsPage("tag", alice.ID, 2, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("TagSweetsPage", posts, older)
+
+	posts, older, _, err = st.MentionsPage(alice.ID, 2, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("MentionsPage", posts, older)
+
+	posts, older, _, err = st.Posts.UserSweetsPage(carol.ID, alice.ID, 2, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("UserSweetsPage", posts, older)
+
+	q := parseSearchQuery("検索語")
+	if err := q.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	posts, older, err = st.SearchPage(q, alice.ID, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("SearchPage", posts, older)
+
+	// ブロックされた側からも見えない
+	a := newTestPost(t, st, alice.ID, "#tag")
+	posts, _, _, err = st.TagSweetsPage("tag", bob.ID, 10, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 5 || posts[0].ID == a.ID {
+		t.Errorf("TagSweetsPage(bob) = %v, want without %d", postIDs(posts), a.ID)
+	}
+}
@@ -0,0 +1,14 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE sessions (
+	id VARCHAR(64) PRIMARY KEY,
+	data BLOB NOT NULL,
+	expire_time DATETIME NOT NULL,
+	INDEX sessions_expire_time (expire_time)
+);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE sessions;
@@ -0,0 +1,11 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE users MODIFY hashed_password VARCHAR(255) NOT NULL;
+ALTER TABLE users ADD password_algorithm VARCHAR(20) NOT NULL DEFAULT 'sha256' AFTER salt;
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE users DROP COLUMN password_algorithm;
+ALTER TABLE users MODIFY hashed_password VARCHAR(64) NOT NULL;
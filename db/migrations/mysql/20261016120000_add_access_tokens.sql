@@ -0,0 +1,17 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE access_tokens (
+	id SERIAL PRIMARY KEY,
+	user_id BIGINT UNSIGNED NOT NULL,
+	name VARCHAR(50) NOT NULL,
+	token_hash CHAR(64) NOT NULL UNIQUE,
+	scope VARCHAR(10) NOT NULL,
+	created_at DATETIME NOT NULL,
+	CONSTRAINT usersToAccessTokens FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE access_tokens;
@@ -0,0 +1,9 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE INDEX posts_user_id_created_at_id ON posts(user_id, created_at, id);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP INDEX posts_user_id_created_at_id ON posts;
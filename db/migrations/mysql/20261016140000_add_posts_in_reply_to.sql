@@ -0,0 +1,13 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE posts ADD in_reply_to BIGINT UNSIGNED NULL AFTER user_id;
+CREATE INDEX posts_in_reply_to_created_at_id ON posts(in_reply_to, created_at, id);
+ALTER TABLE posts ADD CONSTRAINT postsToReplies FOREIGN KEY(in_reply_to) REFERENCES posts(id);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE posts DROP FOREIGN KEY postsToReplies;
+DROP INDEX posts_in_reply_to_created_at_id ON posts;
+ALTER TABLE posts DROP COLUMN in_reply_to;
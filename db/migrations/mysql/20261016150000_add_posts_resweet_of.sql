@@ -0,0 +1,13 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE posts ADD resweet_of BIGINT UNSIGNED NULL AFTER in_reply_to;
+CREATE INDEX posts_resweet_of_created_at_id ON posts(resweet_of, created_at, id);
+ALTER TABLE posts ADD CONSTRAINT postsToResweets FOREIGN KEY(resweet_of) REFERENCES posts(id);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE posts DROP FOREIGN KEY postsToResweets;
+DROP INDEX posts_resweet_of_created_at_id ON posts;
+ALTER TABLE posts DROP COLUMN resweet_of;
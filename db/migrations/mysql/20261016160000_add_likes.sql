@@ -0,0 +1,18 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE likes (
+	user_id BIGINT UNSIGNED NOT NULL,
+	post_id BIGINT UNSIGNED NOT NULL,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY(user_id, post_id),
+	INDEX likes_post_id(post_id),
+	INDEX likes_user_id_created_at_post_id(user_id, created_at, post_id),
+	CONSTRAINT usersToLikes FOREIGN KEY(user_id) REFERENCES users(id),
+	CONSTRAINT postsToLikes FOREIGN KEY(post_id) REFERENCES posts(id)
+);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE likes;
@@ -0,0 +1,20 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE posts ADD edited_at DATETIME NULL AFTER created_at;
+ALTER TABLE posts ADD deleted_at DATETIME NULL AFTER edited_at;
+CREATE TABLE post_revisions (
+	id SERIAL PRIMARY KEY,
+	post_id BIGINT UNSIGNED NOT NULL,
+	message VARCHAR(140) NOT NULL,
+	created_at DATETIME NOT NULL,
+	INDEX post_revisions_post_id_created_at(post_id, created_at),
+	CONSTRAINT postsToPostRevisions FOREIGN KEY(post_id) REFERENCES posts(id)
+);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE post_revisions;
+ALTER TABLE posts DROP COLUMN deleted_at;
+ALTER TABLE posts DROP COLUMN edited_at;
@@ -0,0 +1,17 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE post_mentions (
+	post_id BIGINT UNSIGNED NOT NULL,
+	user_id BIGINT UNSIGNED NOT NULL,
+	name VARCHAR(30) NOT NULL,
+	PRIMARY KEY(post_id, user_id),
+	INDEX post_mentions_user_id_post_id(user_id, post_id),
+	CONSTRAINT postsToPostMentions FOREIGN KEY(post_id) REFERENCES posts(id),
+	CONSTRAINT usersToPostMentions FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE post_mentions;
@@ -0,0 +1,23 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE tags (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(140) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL UNIQUE
+);
+CREATE TABLE post_tags (
+	post_id BIGINT UNSIGNED NOT NULL,
+	tag_id BIGINT UNSIGNED NOT NULL,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY(post_id, tag_id),
+	INDEX post_tags_tag_id_post_id(tag_id, post_id),
+	INDEX post_tags_created_at(created_at),
+	CONSTRAINT postsToPostTags FOREIGN KEY(post_id) REFERENCES posts(id),
+	CONSTRAINT tagsToPostTags FOREIGN KEY(tag_id) REFERENCES tags(id)
+);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE post_tags;
+DROP TABLE tags;
@@ -0,0 +1,9 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE FULLTEXT INDEX posts_message_fulltext ON posts(message) WITH PARSER ngram;
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP INDEX posts_message_fulltext ON posts;
@@ -0,0 +1,19 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+-- user_idはフォローするユーザ, follower_idはフォローされるユーザとして使われていたので名前を改める
+ALTER TABLE followers CHANGE follower_id followee_user_id BIGINT UNSIGNED NOT NULL;
+ALTER TABLE followers CHANGE user_id follower_user_id BIGINT UNSIGNED NOT NULL;
+UPDATE followers SET created_at = NOW() WHERE created_at IS NULL;
+ALTER TABLE followers MODIFY created_at DATETIME NOT NULL;
+CREATE INDEX followers_follower_user_id_created_at ON followers(follower_user_id, created_at, followee_user_id);
+CREATE INDEX followers_followee_user_id_created_at ON followers(followee_user_id, created_at, follower_user_id);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP INDEX followers_followee_user_id_created_at ON followers;
+DROP INDEX followers_follower_user_id_created_at ON followers;
+ALTER TABLE followers MODIFY created_at DATETIME;
+ALTER TABLE followers CHANGE follower_user_id user_id BIGINT UNSIGNED;
+ALTER TABLE followers CHANGE followee_user_id follower_id BIGINT UNSIGNED;
@@ -0,0 +1,28 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE blocks (
+	user_id BIGINT UNSIGNED NOT NULL,
+	blocked_user_id BIGINT UNSIGNED NOT NULL,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY(user_id, blocked_user_id),
+	INDEX blocks_blocked_user_id_user_id(blocked_user_id, user_id),
+	CONSTRAINT usersToBlocks FOREIGN KEY(user_id) REFERENCES users(id),
+	CONSTRAINT blockedUsersToBlocks FOREIGN KEY(blocked_user_id) REFERENCES users(id)
+);
+
+CREATE TABLE mutes (
+	user_id BIGINT UNSIGNED NOT NULL,
+	muted_user_id BIGINT UNSIGNED NOT NULL,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY(user_id, muted_user_id),
+	INDEX mutes_muted_user_id(muted_user_id),
+	CONSTRAINT usersToMutes FOREIGN KEY(user_id) REFERENCES users(id),
+	CONSTRAINT mutedUsersToMutes FOREIGN KEY(muted_user_id) REFERENCES users(id)
+);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE mutes;
+DROP TABLE blocks;
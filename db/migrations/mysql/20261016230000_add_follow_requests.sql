@@ -0,0 +1,20 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE users ADD protected BOOLEAN NOT NULL DEFAULT FALSE AFTER password_algorithm;
+
+CREATE TABLE follow_requests (
+	requester_user_id BIGINT UNSIGNED NOT NULL,
+	target_user_id BIGINT UNSIGNED NOT NULL,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY(requester_user_id, target_user_id),
+	INDEX follow_requests_target_user_id_created_at(target_user_id, created_at),
+	CONSTRAINT requestersToFollowRequests FOREIGN KEY(requester_user_id) REFERENCES users(id),
+	CONSTRAINT targetsToFollowRequests FOREIGN KEY(target_user_id) REFERENCES users(id)
+);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE follow_requests;
+ALTER TABLE users DROP COLUMN protected;
@@ -0,0 +1,22 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE notifications (
+	id SERIAL PRIMARY KEY,
+	user_id BIGINT UNSIGNED NOT NULL,
+	actor_user_id BIGINT UNSIGNED NOT NULL,
+	type VARCHAR(20) NOT NULL,
+	post_id BIGINT UNSIGNED NULL,
+	created_at DATETIME NOT NULL,
+	read_at DATETIME NULL,
+	INDEX notifications_user_id_created_at_id(user_id, created_at, id),
+	INDEX notifications_user_id_read_at(user_id, read_at),
+	CONSTRAINT usersToNotifications FOREIGN KEY(user_id) REFERENCES users(id),
+	CONSTRAINT actorsToNotifications FOREIGN KEY(actor_user_id) REFERENCES users(id),
+	CONSTRAINT postsToNotifications FOREIGN KEY(post_id) REFERENCES posts(id)
+);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE notifications;
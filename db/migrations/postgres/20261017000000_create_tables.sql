@@ -0,0 +1,164 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE users (
+	id BIGSERIAL PRIMARY KEY,
+	name VARCHAR(30) NOT NULL,
+	email VARCHAR(50) NOT NULL UNIQUE,
+	hashed_password VARCHAR(255) NOT NULL,
+	salt VARCHAR(30) NOT NULL,
+	password_algorithm VARCHAR(20) NOT NULL DEFAULT 'sha256',
+	protected BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE posts (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	in_reply_to BIGINT NULL,
+	resweet_of BIGINT NULL,
+	message VARCHAR(140) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	edited_at TIMESTAMP NULL,
+	deleted_at TIMESTAMP NULL,
+	CONSTRAINT usersToPosts FOREIGN KEY(user_id) REFERENCES users(id),
+	CONSTRAINT postsToReplies FOREIGN KEY(in_reply_to) REFERENCES posts(id),
+	CONSTRAINT postsToResweets FOREIGN KEY(resweet_of) REFERENCES posts(id)
+);
+CREATE INDEX posts_user_id_created_at_id ON posts(user_id, created_at, id);
+CREATE INDEX posts_in_reply_to_created_at_id ON posts(in_reply_to, created_at, id);
+CREATE INDEX posts_resweet_of_created_at_id ON posts(resweet_of, created_at, id);
+
+CREATE TABLE followers (
+	follower_user_id BIGINT NOT NULL,
+	followee_user_id BIGINT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(follower_user_id, followee_user_id)
+);
+CREATE INDEX followers_follower_user_id_created_at ON followers(follower_user_id, created_at, followee_user_id);
+CREATE INDEX followers_followee_user_id_created_at ON followers(followee_user_id, created_at, follower_user_id);
+
+CREATE TABLE sessions (
+	id VARCHAR(64) PRIMARY KEY,
+	data BYTEA NOT NULL,
+	expire_time TIMESTAMP NOT NULL
+);
+CREATE INDEX sessions_expire_time ON sessions(expire_time);
+
+CREATE TABLE access_tokens (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	name VARCHAR(50) NOT NULL,
+	token_hash CHAR(64) NOT NULL UNIQUE,
+	scope VARCHAR(10) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	CONSTRAINT usersToAccessTokens FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE likes (
+	user_id BIGINT NOT NULL,
+	post_id BIGINT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(user_id, post_id),
+	CONSTRAINT usersToLikes FOREIGN KEY(user_id) REFERENCES users(id),
+	CONSTRAINT postsToLikes FOREIGN KEY(post_id) REFERENCES posts(id)
+);
+CREATE INDEX likes_post_id ON likes(post_id);
+CREATE INDEX likes_user_id_created_at_post_id ON likes(user_id, created_at, post_id);
+
+CREATE TABLE post_revisions (
+	id BIGSERIAL PRIMARY KEY,
+	post_id BIGINT NOT NULL,
+	message VARCHAR(140) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	CONSTRAINT postsToPostRevisions FOREIGN KEY(post_id) REFERENCES posts(id)
+);
+CREATE INDEX post_revisions_post_id_created_at ON post_revisions(post_id, created_at);
+
+CREATE TABLE post_mentions (
+	post_id BIGINT NOT NULL,
+	user_id BIGINT NOT NULL,
+	name VARCHAR(30) NOT NULL,
+	PRIMARY KEY(post_id, user_id),
+	CONSTRAINT postsToPostMentions FOREIGN KEY(post_id) REFERENCES posts(id),
+	CONSTRAINT usersToPostMentions FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE INDEX post_mentions_user_id_post_id ON post_mentions(user_id, post_id);
+
+CREATE TABLE tags (
+	id BIGSERIAL PRIMARY KEY,
+	name VARCHAR(140) NOT NULL UNIQUE
+);
+
+CREATE TABLE post_tags (
+	post_id BIGINT NOT NULL,
+	tag_id BIGINT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(post_id, tag_id),
+	CONSTRAINT postsToPostTags FOREIGN KEY(post_id) REFERENCES posts(id),
+	CONSTRAINT tagsToPostTags FOREIGN KEY(tag_id) REFERENCES tags(id)
+);
+CREATE INDEX post_tags_tag_id_post_id ON post_tags(tag_id, post_id);
+CREATE INDEX post_tags_created_at ON post_tags(created_at);
+
+CREATE TABLE blocks (
+	user_id BIGINT NOT NULL,
+	blocked_user_id BIGINT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(user_id, blocked_user_id),
+	CONSTRAINT usersToBlocks FOREIGN KEY(user_id) REFERENCES users(id),
+	CONSTRAINT blockedUsersToBlocks FOREIGN KEY(blocked_user_id) REFERENCES users(id)
+);
+CREATE INDEX blocks_blocked_user_id_user_id ON blocks(blocked_user_id, user_id);
+
+CREATE TABLE mutes (
+	user_id BIGINT NOT NULL,
+	muted_user_id BIGINT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(user_id, muted_user_id),
+	CONSTRAINT usersToMutes FOREIGN KEY(user_id) REFERENCES users(id),
+	CONSTRAINT mutedUsersToMutes FOREIGN KEY(muted_user_id) REFERENCES users(id)
+);
+CREATE INDEX mutes_muted_user_id ON mutes(muted_user_id);
+
+CREATE TABLE follow_requests (
+	requester_user_id BIGINT NOT NULL,
+	target_user_id BIGINT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY(requester_user_id, target_user_id),
+	CONSTRAINT requestersToFollowRequests FOREIGN KEY(requester_user_id) REFERENCES users(id),
+	CONSTRAINT targetsToFollowRequests FOREIGN KEY(target_user_id) REFERENCES users(id)
+);
+CREATE INDEX follow_requests_target_user_id_created_at ON follow_requests(target_user_id, created_at);
+
+CREATE TABLE notifications (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	actor_user_id BIGINT NOT NULL,
+	type VARCHAR(20) NOT NULL,
+	post_id BIGINT NULL,
+	created_at TIMESTAMP NOT NULL,
+	read_at TIMESTAMP NULL,
+	CONSTRAINT usersToNotifications FOREIGN KEY(user_id) REFERENCES users(id),
+	CONSTRAINT actorsToNotifications FOREIGN KEY(actor_user_id) REFERENCES users(id),
+	CONSTRAINT postsToNotifications FOREIGN KEY(post_id) REFERENCES posts(id)
+);
+CREATE INDEX notifications_user_id_created_at_id ON notifications(user_id, created_at, id);
+CREATE INDEX notifications_user_id_read_at ON notifications(user_id, read_at);
+
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE notifications;
+DROP TABLE follow_requests;
+DROP TABLE mutes;
+DROP TABLE blocks;
+DROP TABLE post_tags;
+DROP TABLE tags;
+DROP TABLE post_mentions;
+DROP TABLE post_revisions;
+DROP TABLE likes;
+DROP TABLE access_tokens;
+DROP TABLE sessions;
+DROP TABLE followers;
+DROP TABLE posts;
+DROP TABLE users;
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Dialect はDBごとに異なるSQLの書き方をまとめたもの
+// SQLは全てMySQLの書き方(プレースホルダは?)で書き, 異なる部分のみDialectで組み立てる
+type Dialect interface {
+	// Name は設定のDBDriverに指定する名前を返す
+	Name() string
+	// DriverName はsql.Openに渡すドライバ名を返す
+	DriverName() string
+	// DSN はconfigの接続先をドライバに渡す文字列にする
+	DSN(config *Config) string
+	// Rebind はqueryのプレースホルダ?をドライバの書き方にする
+	Rebind(query string) string
+	// InsertIgnore はINSERT INTOで始まるqueryを既に存在する行を無視して登録するINSERT文にする
+	InsertIgnore(query string) string
+	// Upsert は主キーkeyが重複したらcolumnsを更新するINSERT文を返す
+	Upsert(table string, key string, columns []string) string
+	// Contains はcolumnがプレースホルダの文字列を含む条件を返す. 大文字小文字は区別しない
+	Contains(column string) string
+	// GroupConcat はexprをsepで連結する集約関数を返す
+	GroupConcat(expr string, sep string) string
+	// Concat は引数を文字列として連結する式を返す
+	Concat(exprs ...string) string
+	// Union は各SELECT文をUNIONでまとめる. 各SELECT文はORDER BYとLIMITを持ってよい
+	Union(queries ...string) string
+	// ReturningID はINSERT文で登録したIDをRETURNINGで受け取る場合にtrueを返す
+	ReturningID() bool
+	// MigrationTable はgooseと同じマイグレーションの適用履歴のテーブルを作るCREATE文を返す
+	MigrationTable() string
+}
+
+// MySQLDialect はMySQL用のDialect
+type MySQLDialect struct{}
+
+// Name はmysqlを返す
+func (MySQLDialect) Name() string { return "mysql" }
+
+// DriverName はgo-sql-driver/mysqlのドライバ名を返す
+func (MySQLDialect) DriverName() string { return "mysql" }
+
+// DSN はuser:password@tcp(host:port)/dbname?parseTime=trueの形式で返す
+// DBHostとDBPortがなければ既定のアドレスへ接続する
+func (MySQLDialect) DSN(config *Config) string {
+	c := mysql.NewConfig()
+	c.User = config.DBUser
+	c.Passwd = config.DBPassword
+	c.DBName = config.DBName
+	c.ParseTime = true
+	if config.DBHost != "" || config.DBPort != 0 {
+		host := config.DBHost
+		if host == "" {
+			host = "localhost"
+		}
+		port := config.DBPort
+		if port == 0 {
+			port = 3306
+		}
+		c.Net = "tcp"
+		c.Addr = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	switch config.DBTLS {
+	case "disable":
+		c.TLSConfig = "false"
+	case "require":
+		c.TLSConfig = "skip-verify"
+	case "verify":
+		c.TLSConfig = "true"
+	}
+	return c.FormatDSN()
+}
+
+// Rebind はqueryをそのまま返す
+func (MySQLDialect) Rebind(query string) string { return query }
+
+// InsertIgnore はINSERT IGNOREを使う
+func (MySQLDialect) InsertIgnore(query string) string {
+	return replaceInsert(query, "INSERT IGNORE INTO")
+}
+
+// Upsert はON DUPLICATE KEY UPDATEを使う
+func (MySQLDialect) Upsert(table string, key string, columns []string) string {
+	sets := make([]string, 0, len(columns))
+	for _, c := range columns {
+		sets = append(sets, c+" = VALUES("+c+")")
+	}
+	all := key + ", " + strings.Join(columns, ", ")
+	return "INSERT INTO " + table + "(" + all + ") VALUES(" + valuesPlaceholders(all) + ") ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+// Contains はCONCATで%を付けたLIKEを使う. 照合順序で大文字小文字を区別しない
+func (MySQLDialect) Contains(column string) string {
+	return column + " LIKE CONCAT('%', ?, '%')"
+}
+
+// GroupConcat はGROUP_CONCATを使う
+func (MySQLDialect) GroupConcat(expr string, sep string) string {
+	return "GROUP_CONCAT(" + expr + " SEPARATOR '" + sep + "')"
+}
+
+// Concat はCONCATを使う
+func (MySQLDialect) Concat(exprs ...string) string {
+	return "CONCAT(" + strings.Join(exprs, ", ") + ")"
+}
+
+// Union は各SELECT文を括弧で囲む
+func (MySQLDialect) Union(queries ...string) string {
+	return "(" + strings.Join(queries, ")\n\t\tUNION\n\t\t(") + ")"
+}
+
+// ReturningID はLastInsertIdを使うのでfalseを返す
+func (MySQLDialect) ReturningID() bool { return false }
+
+// MigrationTable はgooseのMySQL用の定義を返す
+func (MySQLDialect) MigrationTable() string {
+	return "CREATE TABLE " + MigrationTableName + " (id serial NOT NULL, version_id bigint NOT NULL, is_applied boolean NOT NULL, tstamp timestamp NULL default now(), PRIMARY KEY(id))"
+}
+
+// SQLiteDialect はSQLite用のDialect
+// サーバなしで動かせるので開発やCIで使う
+type SQLiteDialect struct{}
+
+// Name はsqlite3を返す
+func (SQLiteDialect) Name() string { return "sqlite3" }
+
+// DriverName はmattn/go-sqlite3のドライバ名を返す
+func (SQLiteDialect) DriverName() string { return "sqlite3" }
+
+// DSN はDBNameをファイルのパスとして外部キー制約を有効にする
+// 書き込みが重なった場合はロックの解放を待つ
+func (SQLiteDialect) DSN(config *Config) string {
+	return "file:" + config.DBName + "?_foreign_keys=on&_busy_timeout=5000&_loc=auto"
+}
+
+// Rebind はqueryをそのまま返す
+func (SQLiteDialect) Rebind(query string) string { return query }
+
+// InsertIgnore はINSERT OR IGNOREを使う
+func (SQLiteDialect) InsertIgnore(query string) string {
+	return replaceInsert(query, "INSERT OR IGNORE INTO")
+}
+
+// Upsert はON CONFLICT DO UPDATEを使う
+func (SQLiteDialect) Upsert(table string, key string, columns []string) string {
+	return onConflictUpsert(table, key, columns)
+}
+
+// Contains は||で%を付けたLIKEを使う. ASCIIの大文字小文字は区別しない
+func (SQLiteDialect) Contains(column string) string {
+	return column + " LIKE '%' || ? || '%'"
+}
+
+// GroupConcat はgroup_concatを使う
+func (SQLiteDialect) GroupConcat(expr string, sep string) string {
+	return "group_concat(" + expr + ", '" + sep + "')"
+}
+
+// Concat は||を使う
+func (SQLiteDialect) Concat(exprs ...string) string {
+	return "(" + strings.Join(exprs, " || ") + ")"
+}
+
+// Union は各SELECT文をサブクエリにする
+// SQLiteはUNIONの各SELECT文にORDER BYとLIMITを書けない
+func (SQLiteDialect) Union(queries ...string) string {
+	return derivedUnion(queries)
+}
+
+// ReturningID はLastInsertIdを使うのでfalseを返す
+func (SQLiteDialect) ReturningID() bool { return false }
+
+// MigrationTable はgooseのSQLite用の定義を返す
+func (SQLiteDialect) MigrationTable() string {
+	return "CREATE TABLE " + MigrationTableName + " (id INTEGER PRIMARY KEY AUTOINCREMENT, version_id INTEGER NOT NULL, is_applied INTEGER NOT NULL, tstamp TIMESTAMP DEFAULT (datetime('now')))"
+}
+
+// PostgresDialect はPostgreSQL用のDialect
+type PostgresDialect struct{}
+
+// Name はpostgresを返す
+func (PostgresDialect) Name() string { return "postgres" }
+
+// DriverName はlib/pqのドライバ名を返す
+func (PostgresDialect) DriverName() string { return "postgres" }
+
+// DSN はkey=valueの形式で返す
+// DBTLSはsslmodeのdisable, require, verify-fullにする
+func (PostgresDialect) DSN(config *Config) string {
+	params := []string{"dbname=" + pqQuote(config.DBName), "user=" + pqQuote(config.DBUser)}
+	if config.DBPassword != "" {
+		params = append(params, "password="+pqQuote(config.DBPassword))
+	}
+	if config.DBHost != "" {
+		params = append(params, "host="+pqQuote(config.DBHost))
+	}
+	if config.DBPort != 0 {
+		params = append(params, "port="+strconv.Itoa(config.DBPort))
+	}
+	switch config.DBTLS {
+	case "disable":
+		params = append(params, "sslmode=disable")
+	case "require":
+		params = append(params, "sslmode=require")
+	case "verify":
+		params = append(params, "sslmode=verify-full")
+	}
+	return strings.Join(params, " ")
+}
+
+// Rebind は?を$1, $2...にする. 文字列リテラル内の?はそのままにする
+func (PostgresDialect) Rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && quoted == false:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// InsertIgnore はON CONFLICT DO NOTHINGを使う
+func (PostgresDialect) InsertIgnore(query string) string {
+	return strings.TrimSpace(query) + " ON CONFLICT DO NOTHING"
+}
+
+// Upsert はON CONFLICT DO UPDATEを使う
+func (PostgresDialect) Upsert(table string, key string, columns []string) string {
+	return onConflictUpsert(table, key, columns)
+}
+
+// Contains はILIKEを使う. プレースホルダの型が決まらないのでtextへ変換する
+func (PostgresDialect) Contains(column string) string {
+	return column + " ILIKE '%' || CAST(? AS TEXT) || '%'"
+}
+
+// GroupConcat はstring_aggを使う
+func (PostgresDialect) GroupConcat(expr string, sep string) string {
+	return "string_agg(" + expr + ", '" + sep + "')"
+}
+
+// Concat はCONCATを使う
+func (PostgresDialect) Concat(exprs ...string) string {
+	return "CONCAT(" + strings.Join(exprs, ", ") + ")"
+}
+
+// Union は各SELECT文をサブクエリにする
+func (PostgresDialect) Union(queries ...string) string {
+	return derivedUnion(queries)
+}
+
+// ReturningID はlib/pqがLastInsertIdに対応していないのでtrueを返す
+func (PostgresDialect) ReturningID() bool { return true }
+
+// MigrationTable はgooseのPostgreSQL用の定義を返す
+func (PostgresDialect) MigrationTable() string {
+	return "CREATE TABLE " + MigrationTableName + " (id serial NOT NULL, version_id bigint NOT NULL, is_applied boolean NOT NULL, tstamp timestamp NULL default now(), PRIMARY KEY(id))"
+}
+
+// queryの最初のINSERT INTOをinsertにする
+func replaceInsert(query string, insert string) string {
+	return strings.Replace(query, "INSERT INTO", insert, 1)
+}
+
+// "a, b, c"の列の数だけ?を並べる
+func valuesPlaceholders(columns string) string {
+	n := len(strings.Split(columns, ","))
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// SQLiteとPostgreSQLで共通のON CONFLICT DO UPDATEによるUpsert
+func onConflictUpsert(table string, key string, columns []string) string {
+	sets := make([]string, 0, len(columns))
+	for _, c := range columns {
+		sets = append(sets, c+" = excluded."+c)
+	}
+	all := key + ", " + strings.Join(columns, ", ")
+	return "INSERT INTO " + table + "(" + all + ") VALUES(" + valuesPlaceholders(all) + ") ON CONFLICT(" + key + ") DO UPDATE SET " + strings.Join(sets, ", ")
+}
+
+// 各SELECT文をFROM句のサブクエリにしてUNIONでまとめる
+func derivedUnion(queries []string) string {
+	parts := make([]string, 0, len(queries))
+	for i, q := range queries {
+		parts = append(parts, fmt.Sprintf("SELECT * FROM (%s) AS u%d", q, i))
+	}
+	return strings.Join(parts, "\n\t\tUNION\n\t\t")
+}
+
+// lib/pqの接続文字列の値を'で囲む
+func pqQuote(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
+
+// 設定のDBDriverに対応するDialectを返す
+// 未指定の場合はMySQLを使う
+func newDialect(config *Config) (Dialect, error) {
+	switch config.DBDriver {
+	case "", "mysql":
+		return MySQLDialect{}, nil
+	case "sqlite3":
+		return SQLiteDialect{}, nil
+	case "postgres":
+		return PostgresDialect{}, nil
+	default:
+		return nil, errors.New("unknown db driver: " + config.DBDriver)
+	}
+}
+
+// DB は*sql.DBへ渡すSQLをDialectに合わせて書き換える
+type DB struct {
+	*sql.DB
+	dialect Dialect
+}
+
+// Query はqueryを書き換えてから実行する
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.Rebind(query), args...)
+}
+
+// QueryRow はqueryを書き換えてから実行する
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.dialect.Rebind(query), args...)
+}
+
+// Exec はqueryを書き換えてから実行する
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.Rebind(query), args...)
+}
+
+// Prepare はqueryを書き換えてからプリペアードステートメントを生成する
+func (db *DB) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.Prepare(db.dialect.Rebind(query))
+}
+
+// Begin はトランザクションを開始する
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, dialect: db.dialect}, nil
+}
+
+// Insert はINSERT文のqueryを実行して登録した行のIDを返す
+func (db *DB) Insert(query string, args ...interface{}) (int64, error) {
+	return insertID(db.dialect, db.DB, query, args)
+}
+
+// Tx は*sql.Txへ渡すSQLをDialectに合わせて書き換える
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+// Query はqueryを書き換えてから実行する
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.Rebind(query), args...)
+}
+
+// QueryRow はqueryを書き換えてから実行する
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.Rebind(query), args...)
+}
+
+// Exec はqueryを書き換えてから実行する
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.Rebind(query), args...)
+}
+
+// Prepare はqueryを書き換えてからプリペアードステートメントを生成する
+func (tx *Tx) Prepare(query string) (*sql.Stmt, error) {
+	return tx.Tx.Prepare(tx.dialect.Rebind(query))
+}
+
+// Insert はINSERT文のqueryを実行して登録した行のIDを返す
+func (tx *Tx) Insert(query string, args ...interface{}) (int64, error) {
+	return insertID(tx.dialect, tx.Tx, query, args)
+}
+
+// DBとTxのどちらでもINSERT文を実行出来るようにする
+type inserter interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// INSERT文を実行して登録した行のIDを返す
+// RETURNINGを使うDialectではqueryの末尾にRETURNING idを付ける
+func insertID(d Dialect, e inserter, query string, args []interface{}) (int64, error) {
+	if d.ReturningID() == true {
+		var id int64
+		err := e.QueryRow(d.Rebind(query+" RETURNING id"), args...).Scan(&id)
+		return id, err
+	}
+	result, err := e.Exec(d.Rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FollowRequest は鍵アカウントへのフォローリクエスト
+// 承認されるとフォローになる
+type FollowRequest struct {
+	RequesterID int64 // リクエストしたユーザのID
+	TargetID    int64 // リクエストされた鍵アカウントのユーザのID
+}
+
+// FollowRequestsForTemplate はフォローリクエスト一覧画面用のデータ構造
+type FollowRequestsForTemplate struct {
+	Users []User // 承認待ちのリクエストをしたユーザ. 古い順
+}
+
+// Approve はフォローリクエストを承認してフォローにする
+// 承認待ちのリクエストがなければfalseを返す
+func (fr *FollowRequest) Approve(st *Store) (bool, error) {
+	db := st.db
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// リクエストの削除
+	result, err := tx.Exec(`
+		DELETE
+		FROM
+			follow_requests
+		WHERE
+			requester_user_id = ?
+		AND
+			target_user_id = ?
+	`, fr.RequesterID, fr.TargetID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	// フォローの登録
+	_, err = tx.Exec(tx.dialect.InsertIgnore("INSERT INTO followers(follower_user_id, followee_user_id, created_at) VALUES(?, ?, ?)"), fr.RequesterID, fr.TargetID, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	// 購読者へ配信
+	st.Hub.Publish(HubEvent{Type: HubEventFollow, UserID: fr.RequesterID, TargetUserID: fr.TargetID})
+	return true, nil
+}
+
+// Reject はフォローリクエストを拒否する
+// 承認待ちのリクエストがなければfalseを返す
+func (fr *FollowRequest) Reject(st *Store) (bool, error) {
+	db := st.db
+
+	// プリペアードステートメント生成
+	stmt, err := db.Prepare(`
+		DELETE
+		FROM
+			follow_requests
+		WHERE
+			requester_user_id = ?
+		AND
+			target_user_id = ?
+	`)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	// クエリ発行
+	result, err := stmt.Exec(fr.RequesterID, fr.TargetID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// setProtected はuserIDの鍵アカウントの設定を変更する
+// 鍵アカウントをやめる場合は承認待ちのリクエストを全て承認する
+func (st *Store) setProtected(userID int64, protected bool) error {
+	db := st.db
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 設定の変更
+	_, err = tx.Exec("UPDATE users SET protected = ? WHERE id = ?", protected, userID)
+	if err != nil {
+		return err
+	}
+	if protected == true {
+		return tx.Commit()
+	}
+
+	// 承認待ちのリクエストをフォローにする
+	_, err = tx.Exec(tx.dialect.InsertIgnore(`
+		INSERT INTO followers(follower_user_id, followee_user_id, created_at)
+		SELECT
+			r.requester_user_id,
+			r.target_user_id,
+			?
+		FROM
+			follow_requests r
+		WHERE
+			r.target_user_id = ?
+	`), time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM follow_requests WHERE target_user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isProtectedUser はuserIDが鍵アカウントであればtrueを返す
+// 存在しないユーザはfalse
+func (st *Store) isProtectedUser(userID int64) (bool, error) {
+	db := st.db
+
+	// クエリ発行
+	var count int64
+	err := db.QueryRow(`
+	SELECT
+		COUNT(*)
+	FROM
+		users u
+	WHERE
+		u.id = ?
+	AND
+		u.protected = TRUE
+	`, userID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// isFollowRequested はrequesterIDがtargetIDへ承認待ちのフォローリクエストをしていればtrueを返す
+func (st *Store) isFollowRequested(requesterID int64, targetID int64) (bool, error) {
+	db := st.db
+
+	// クエリ発行
+	var count int64
+	err := db.QueryRow(`
+	SELECT
+		COUNT(*)
+	FROM
+		follow_requests r
+	WHERE
+		r.requester_user_id = ?
+	AND
+		r.target_user_id = ?
+	`, requesterID, targetID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// countFollowRequests はuserIDへの承認待ちのフォローリクエストの数を返す
+func (st *Store) countFollowRequests(userID int64) (int64, error) {
+	db := st.db
+
+	// クエリ発行
+	var count int64
+	err := db.QueryRow(`
+	SELECT
+		COUNT(*)
+	FROM
+		follow_requests r
+	WHERE
+		r.target_user_id = ?
+	`, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// findFollowRequesters はuserIDへ承認待ちのフォローリクエストをしたユーザ一覧を古い順に返す
+func (st *Store) findFollowRequesters(userID int64) ([]User, error) {
+	db := st.db
+	// SQL発行
+	rows, err := db.Query(`
+		SELECT
+			u.id,
+			u.name
+		FROM
+			follow_requests r
+		INNER JOIN
+			users u
+		ON
+			u.id = r.requester_user_id
+		WHERE
+			r.target_user_id = ?
+		ORDER BY
+			r.created_at, u.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Name); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// canViewSweetsOf はviewerIDがuserIDのsweetを閲覧出来ればtrueを返す
+// 鍵アカウントのsweetは本人と承認したフォロワーのみ閲覧出来る
+func (st *Store) canViewSweetsOf(viewerID int64, userID int64) (bool, error) {
+	if viewerID == userID {
+		return true, nil
+	}
+	protected, err := st.isProtectedUser(userID)
+	if err != nil || protected == false {
+		return true, err
+	}
+	return st.Followers.IsFollowing(viewerID, userID)
+}
+
+// isVisibleUser はviewerIDにuserIDのsweetを表示出来ればtrueを返す
+// ブロック関係にあるユーザと閲覧出来ない鍵アカウントのsweetは表示しない
+func (st *Store) isVisibleUser(viewerID int64, userID int64) (bool, error) {
+	blocked, err := st.isBlockedEither(viewerID, userID)
+	if err != nil || blocked == true {
+		return false, err
+	}
+	return st.canViewSweetsOf(viewerID, userID)
+}
+
+// userIDsのうちviewerIDが閲覧出来ない鍵アカウントのユーザのIDを返す
+func (st *Store) hiddenProtectedUserIDs(viewerID int64, userIDs []int64) (map[int64]bool, error) {
+	ids := make(map[int64]bool)
+	if len(userIDs) == 0 {
+		return ids, nil
+	}
+	db := st.db
+	// パラメータ組み立て
+	placeholders := make([]string, 0, len(userIDs))
+	args := make([]interface{}, 0, len(userIDs)+2)
+	for _, id := range userIDs {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+	args = append(args, viewerID, viewerID)
+
+	// SQL発行
+	rows, err := db.Query(`
+		SELECT
+			u.id
+		FROM
+			users u
+		WHERE
+			u.id IN (`+strings.Join(placeholders, ", ")+`)
+		AND
+			u.protected = TRUE
+		AND
+			u.id <> ?
+		AND
+			NOT EXISTS (
+				SELECT
+					1
+				FROM
+					followers f
+				WHERE
+					f.follower_user_id = ?
+				AND
+					f.followee_user_id = u.id
+			)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// hideInvisible はpostsからuserIDに表示出来ないPostを除いて返す
+// ブロック関係にあるユーザと閲覧出来ない鍵アカウントのPostを除く. リスイート元の投稿者も対象にする
+func (st *Store) hideInvisible(userID int64, posts []Post) ([]Post, error) {
+	posts, err := st.hideBlocked(userID, posts)
+	if err != nil {
+		return nil, err
+	}
+	// 投稿者を重複なく集める
+	userIDs := make([]int64, 0, len(posts))
+	seen := make(map[int64]bool)
+	for _, p := range posts {
+		authors := []int64{p.UserID}
+		if p.Original != nil {
+			authors = append(authors, p.Original.UserID)
+		}
+		for _, id := range authors {
+			if seen[id] == false {
+				seen[id] = true
+				userIDs = append(userIDs, id)
+			}
+		}
+	}
+	hidden, err := st.hiddenProtectedUserIDs(userID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(hidden) == 0 {
+		return posts, nil
+	}
+	visible := make([]Post, 0, len(posts))
+	for _, p := range posts {
+		if hidden[p.UserID] == true || (p.Original != nil && hidden[p.Original.UserID] == true) {
+			continue
+		}
+		visible = append(visible, p)
+	}
+	return visible, nil
+}
+
+// [/follow_requests]のハンドラ
+func (app *App) followRequestsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は存在しない
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	fft := &FollowRequestsForTemplate{}
+	fft.Users, err = app.findFollowRequesters(uid)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	err = responseTemplate.ExecuteTemplate(w, "followRequests.tmpl", fft)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+	}
+}
+
+// [/follow_requests/approve]のハンドラ
+func (app *App) approveFollowRequestHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	uid, requesterID, ok := userTargetFromRequest(w, r, s)
+	if ok == false {
+		return
+	}
+	// 承認
+	fr := &FollowRequest{RequesterID: requesterID, TargetID: uid}
+	if _, err := fr.Approve(app.Store); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/follow_requests", http.StatusFound)
+}
+
+// [/follow_requests/reject]のハンドラ
+func (app *App) rejectFollowRequestHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	uid, requesterID, ok := userTargetFromRequest(w, r, s)
+	if ok == false {
+		return
+	}
+	// 拒否
+	fr := &FollowRequest{RequesterID: requesterID, TargetID: uid}
+	if _, err := fr.Reject(app.Store); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/follow_requests", http.StatusFound)
+}
+
+// [/protect]と[/unprotect]のハンドラ
+func (app *App) setProtectedHandler(w http.ResponseWriter, r *http.Request, s *Session, protected bool) {
+	// POST以外は存在しない
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	// 設定の変更
+	if err := app.setProtected(uid, protected); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/users/"+strconv.FormatInt(uid, 10), http.StatusFound)
+}
+
+// [/protect]のハンドラ
+func (app *App) protectHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	app.setProtectedHandler(w, r, s, true)
+}
+
+// [/unprotect]のハンドラ
+func (app *App) unprotectHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	app.setProtectedHandler(w, r, s, false)
+}
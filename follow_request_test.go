@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 閲覧出来ない鍵アカウントのsweetはLIMITの前に除くので, ページが欠けたり空になったりしない
+func TestProtectedSweetsArePagedInSQL(t *testing.T) {
+	st := newTestStore(t)
+	alice := newTestUser(t, st, "alice")
+	bob := newTestUser(t, st, "bob")
+	carol := newTestUser(t, st, "carol")
+	dave := newTestUser(t, st, "dave")
+	// daveは鍵アカウントにする前からのフォロワー
+	newTestFollow(t, st, dave.ID, alice.ID)
+	if err := st.setProtected(alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// carolの2件より新しいaliceの3件と, それをリスイートしたcarolの3件
+	c1 := newTestPost(t, st, carol.ID, "@bob 検索語 #tag 1")
+	c2 := newTestPost(t, st, carol.ID, "@bob 検索語 #tag 2")
+	var protected []int64
+	for i := 0; i < 3; i++ {
+		a := newTestPost(t, st, alice.ID, "@bob 検索語 #tag")
+		if err := st.Posts.Entry(&Post{UserID: carol.ID, ResweetOf: a.ID}); err != nil {
+			t.Fatal(err)
+		}
+		protected = append([]int64{a.ID}, protected...)
+	}
+	want := []int64{c2.ID, c1.ID}
+
+	check := func(name string, posts []Post, older *TimelineCursor) {
+		t.Helper()
+		if got := postIDs(posts); reflect.DeepEqual(got, want) == false {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+		if older != nil {
+			t.Errorf("%s has older page %s", name, older)
+		}
+	}
+
+	posts, older, _, err := st.TagSweetsPage("tag", bob.ID, 2, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("TagSweetsPage", posts, older)
+
+	posts, older, _, err = st.MentionsPage(bob.ID, 2, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("MentionsPage", posts, older)
+
+	posts, older, _, err = st.Posts.UserSweetsPage(carol.ID, bob.ID, 2, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("UserSweetsPage", posts, older)
+
+	q := parseSearchQuery("検索語")
+	if err := q.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	posts, older, err = st.SearchPage(q, bob.ID, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("SearchPage", posts, older)
+
+	// フォロワーと本人には表示する
+	for _, viewer := range []*User{dave, alice} {
+		posts, _, _, err = st.Posts.UserSweetsPage(alice.ID, viewer.ID, 10, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := postIDs(posts); reflect.DeepEqual(got, protected) == false {
+			t.Errorf("UserSweetsPage(alice, %s) = %v, want %v", viewer.Name, got, protected)
+		}
+	}
+}
@@ -1,141 +1,448 @@
 package main
 
 import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
 	"time"
 )
 
-// FollowerForTemplate はフォロアー検索画面表示制御用の構造体
-type FollowerForTemplate struct {
-	Followers []Follower
+// UserSearchForTemplate はユーザ検索画面表示制御用の構造体
+type UserSearchForTemplate struct {
+	Query string
+	Users []FollowUser
 }
 
-// Follower は画面表示用のフォロアー情報
-type Follower struct {
-	FollowerID int64
-	UserID     int64
-	Name       string
-	Following  bool
+// FollowsForTemplate はフォロー一覧, フォロワー一覧画面用のデータ構造
+type FollowsForTemplate struct {
+	User      User
+	ViewerID  int64 // 閲覧者のID
+	Followers bool  // フォロワー一覧であればtrue, フォロー一覧であればfalse
+	Count     int64 // 一覧の全体の人数
+	Users     []FollowUser
+	Older     string // より古いページのカーソル. なければ空
+	Newer     string // より新しいページのカーソル. なければ空
+}
+
+// Follow はユーザ間のフォロー関係
+// followersテーブルの1行に対応する
+type Follow struct {
+	FollowerID int64 // フォローするユーザのID
+	FolloweeID int64 // フォローされるユーザのID
+	Requested  bool  // 鍵アカウントへのフォローでフォローリクエストになった場合はtrue. Entryで設定する
 	Messages   []string
 }
 
-// queryに部分一致するユーザ一覧を返す
-func findFollowersByQuery(uid int64, query string, limit int, offset int) ([]Follower, error) {
-	// コネクション取得
-	db, err := DBConnection()
-	if err != nil {
-		return nil, err
-	}
+// FollowUser はユーザ一覧に表示するユーザ
+type FollowUser struct {
+	ID         int64
+	Name       string
+	Following  bool      // 閲覧者がフォローしていればtrue
+	FollowedAt time.Time // フォロー一覧, フォロワー一覧でのフォローした日時
+}
+
+// SQLFollowerRepository はfollowersテーブルへ保存するFollowerRepository
+type SQLFollowerRepository struct {
+	st *Store
+}
+
+// Cursor はフォロー一覧, フォロワー一覧でのuの位置を表すカーソルを返す
+// フォローした日時とユーザIDの組
+func (u *FollowUser) Cursor() *TimelineCursor {
+	return &TimelineCursor{CreatedAt: u.FollowedAt, ID: u.ID}
+}
+
+// Search はqueryに名前が部分一致するユーザ一覧を返す
+// FollowingにはviewerIDがフォローしているかが入る
+func (fr *SQLFollowerRepository) Search(viewerID int64, query string, limit int, offset int) ([]FollowUser, error) {
+	db := fr.st.db
 	// SQL発行
 	rows, err := db.Query(`
 		SELECT
 			u.id,
 			u.name,
-			CASE WHEN f.follower_id IS NULL THEN 'NO_FOLLOWING'
+			CASE WHEN v.followee_user_id IS NULL THEN 'NO_FOLLOWING'
 			ELSE 'FOLLOWING'
 			END AS follow_status
 		FROM
 			users u
 		LEFT JOIN
-			followers f
+			followers v
 		ON
-			f.follower_id = u.id
+			v.followee_user_id = u.id
 		AND
-			f.user_id = ?
+			v.follower_user_id = ?
 		WHERE
-			u.name LIKE CONCAT('%', ? ,'%')
+			`+db.dialect.Contains("u.name")+`
 		ORDER BY
 			u.created_at desc
 		LIMIT ?
 		OFFSET ?
-	`, uid, query, limit, offset)
+	`, viewerID, query, limit, offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	followers := make([]Follower, 0)
+	users := make([]FollowUser, 0)
 	for rows.Next() {
-		var f Follower
+		var u FollowUser
 		var followStatus string
-		if err := rows.Scan(&f.FollowerID, &f.Name, &followStatus); err != nil {
+		if err := rows.Scan(&u.ID, &u.Name, &followStatus); err != nil {
 			return nil, err
 		}
 		if followStatus == "NO_FOLLOWING" {
-			f.Following = false
+			u.Following = false
 		} else {
-			f.Following = true
+			u.Following = true
 		}
-		f.UserID = uid
-		followers = append(followers, f)
+		users = append(users, u)
 	}
-	return followers, nil
+	return users, nil
 }
 
-// Validate はFollowerの登録前の入力チェックを行う
-func (f *Follower) Validate() error {
+// Validate はFollowの登録前の入力チェックを行う
+func (f *Follow) Validate(st *Store) error {
 	var messages []string
 
-	if exist, err := isExistUserID(f.UserID); err != nil {
+	if exist, err := st.Users.Exists(f.FollowerID); err != nil {
 		return err
 	} else if exist == false {
 		messages = append(messages, "このフォロワーのアカウントは既に削除されています")
 	}
+
+	// 自分自身はフォローしない
+	if f.FollowerID == f.FolloweeID {
+		messages = append(messages, "自分自身はフォロー出来ません")
+	} else if exist, err := st.Users.Exists(f.FolloweeID); err != nil {
+		return err
+	} else if exist == false {
+		messages = append(messages, "フォローするユーザは存在しません")
+	} else if blocked, err := st.isBlockedEither(f.FollowerID, f.FolloweeID); err != nil {
+		return err
+	} else if blocked == true {
+		// ブロックしている, されているユーザはフォローしない
+		messages = append(messages, "このユーザはフォロー出来ません")
+	}
+
 	f.Messages = messages
 	return nil
 }
 
-// Entry はFollowerの情報登録を行う
-func (f *Follower) Entry() error {
-	// コネクション取得
-	db, err := DBConnection()
+// Entry はFollowの情報登録を行う
+// フォローするユーザが鍵アカウントであればフォローリクエストを登録してRequestedをtrueにする
+// 新たに登録した場合はフォローされるユーザへ通知する. 既にフォローしている, リクエストしている場合は何もしない
+func (fr *SQLFollowerRepository) Entry(f *Follow) error {
+	db := fr.st.db
+
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// プリペアードステートメント生成
-	stmt, err := db.Prepare("INSERT INTO followers(user_id, follower_id, created_at) VALUES(?, ?, ?)")
+	// 鍵アカウントで未フォローであればリクエストにする
+	var protected bool
+	var following int64
+	err = tx.QueryRow(`
+	SELECT
+		u.protected,
+		(SELECT COUNT(*) FROM followers f WHERE f.follower_user_id = ? AND f.followee_user_id = u.id)
+	FROM
+		users u
+	WHERE
+		u.id = ?
+	`, f.FollowerID, f.FolloweeID).Scan(&protected, &following)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
+	f.Requested = protected == true && following == 0
 
-	// クエリ発行
-	_, err = stmt.Exec(f.UserID, f.FollowerID, time.Now())
+	// 登録
+	var result sql.Result
+	notificationType := NotificationFollow
+	if f.Requested == true {
+		result, err = tx.Exec(tx.dialect.InsertIgnore("INSERT INTO follow_requests(requester_user_id, target_user_id, created_at) VALUES(?, ?, ?)"), f.FollowerID, f.FolloweeID, time.Now())
+		notificationType = NotificationFollowRequest
+	} else {
+		result, err = tx.Exec(tx.dialect.InsertIgnore("INSERT INTO followers(follower_user_id, followee_user_id, created_at) VALUES(?, ?, ?)"), f.FollowerID, f.FolloweeID, time.Now())
+	}
 	if err != nil {
 		return err
 	}
 
+	// 通知
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	var events []HubEvent
+	if n > 0 {
+		if f.Requested == false {
+			events = append(events, HubEvent{Type: HubEventFollow, UserID: f.FollowerID, TargetUserID: f.FolloweeID})
+		}
+		e, err := entryNotification(tx, f.FolloweeID, f.FollowerID, notificationType, 0)
+		if err != nil {
+			return err
+		}
+		if e != nil {
+			events = append(events, *e)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// 購読者へ配信
+	fr.st.Hub.Publish(events...)
 	return nil
 }
 
-// Remove はFollowerの情報登録を行う
-func (f *Follower) Remove() error {
-	// コネクション取得
-	db, err := DBConnection()
+// Remove はFollowの情報削除を行う
+// 承認待ちのフォローリクエストも取り消す. フォローしていない場合は何もしない
+func (fr *SQLFollowerRepository) Remove(f *Follow) error {
+	db := fr.st.db
+
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// プリペアードステートメント生成
-	stmt, err := db.Prepare(`
+	// フォローの削除
+	_, err = tx.Exec(`
 		DELETE
 		FROM
 			followers
 		WHERE
-			user_id = ?
+			follower_user_id = ?
 		AND
-			follower_id = ?
-	`)
+			followee_user_id = ?
+	`, f.FollowerID, f.FolloweeID)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	// クエリ発行
-	_, err = stmt.Exec(f.UserID, f.FollowerID)
+	// リクエストの取り消し
+	_, err = tx.Exec(`
+		DELETE
+		FROM
+			follow_requests
+		WHERE
+			requester_user_id = ?
+		AND
+			target_user_id = ?
+	`, f.FollowerID, f.FolloweeID)
 	if err != nil {
 		return err
 	}
+	return tx.Commit()
+}
 
-	return nil
+// Count はuserIDがフォローしている人数とフォローされている人数を返す
+func (fr *SQLFollowerRepository) Count(userID int64) (int64, int64, error) {
+	db := fr.st.db
+
+	// クエリ発行
+	var following, followers int64
+	err := db.QueryRow(`
+	SELECT
+		(SELECT COUNT(*) FROM followers f WHERE f.follower_user_id = ?),
+		(SELECT COUNT(*) FROM followers f WHERE f.followee_user_id = ?)
+	`, userID, userID).Scan(&following, &followers)
+	if err != nil {
+		return 0, 0, err
+	}
+	return following, followers, nil
+}
+
+// IsFollowing はfollowerIDがfolloweeIDをフォローしていればtrueを返す
+func (fr *SQLFollowerRepository) IsFollowing(followerID int64, followeeID int64) (bool, error) {
+	db := fr.st.db
+
+	// クエリ発行
+	var count int64
+	err := db.QueryRow(`
+	SELECT
+		COUNT(*)
+	FROM
+		followers f
+	WHERE
+		f.follower_user_id = ?
+	AND
+		f.followee_user_id = ?
+	`, followerID, followeeID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// フォロー一覧, フォロワー一覧を取得するSQLを組み立てる
+// followersがtrueならフォロワー一覧, falseならフォロー一覧
+// condはfollowers fとusers uに対する条件, orderはASCまたはDESC
+// プレースホルダは閲覧者のID, 一覧のユーザのID, condの引数, limitの順
+func followUsersQuery(followers bool, cond *sqlConds, order string) string {
+	// 一覧のユーザと一覧に表示するユーザの列
+	self, other := "f.follower_user_id", "f.followee_user_id"
+	if followers == true {
+		self, other = other, self
+	}
+	return fmt.Sprintf(`
+		SELECT
+			u.id,
+			u.name,
+			v.followee_user_id IS NOT NULL AS following,
+			f.created_at
+		FROM
+			followers f
+		INNER JOIN
+			users u
+		ON
+			u.id = %[3]s
+		LEFT JOIN
+			followers v
+		ON
+			v.followee_user_id = u.id
+		AND
+			v.follower_user_id = ?
+		WHERE
+			%[4]s = ?
+		AND%[1]s
+		ORDER BY
+			f.created_at %[2]s, u.id %[2]s
+		LIMIT ?
+	`, cond, order, other, self)
+}
+
+// フォロー一覧, フォロワー一覧を取得する
+func (fr *SQLFollowerRepository) queryFollowUsers(followers bool, cond *sqlConds, order string, viewerID int64, userID int64, limit int) ([]FollowUser, error) {
+	db := fr.st.db
+	query := followUsersQuery(followers, cond, order)
+	// パラメータ組み立て
+	var args []interface{}
+	args = append(args, viewerID, userID)
+	args = append(args, cond.args...)
+	args = append(args, limit)
+
+	// SQL発行
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]FollowUser, 0)
+	for rows.Next() {
+		var u FollowUser
+		if err := rows.Scan(&u.ID, &u.Name, &u.Following, &u.FollowedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// Page はuserIDのフォロー一覧またはフォロワー一覧の1ページ分をフォローした新しい順に取得する
+// followersがtrueならフォロワー一覧, falseならフォロー一覧
+// FollowingにはviewerIDがフォローしているかが入る
+// カーソルはフォローした日時とユーザIDの組. beforeとafterの扱いと戻り値はTimelinePageと同じ
+func (fr *SQLFollowerRepository) Page(userID int64, followers bool, viewerID int64, limit int, before *TimelineCursor, after *TimelineCursor) (users []FollowUser, older *TimelineCursor, newer *TimelineCursor, err error) {
+	older, newer, err = pageKeyset(limit, before, after,
+		func(limit int, before *TimelineCursor) (int, error) {
+			cond := (&sqlConds{}).olderThan("f.created_at", "u.id", before)
+			users, err = fr.queryFollowUsers(followers, cond, "DESC", viewerID, userID, limit)
+			return len(users), err
+		},
+		func(limit int, after *TimelineCursor) (int, error) {
+			cond := (&sqlConds{}).newerThan("f.created_at", "u.id", after)
+			users, err = fr.queryFollowUsers(followers, cond, "ASC", viewerID, userID, limit)
+			if err != nil {
+				return 0, err
+			}
+			reverseSlice(users)
+			return len(users), nil
+		},
+		func(i int, j int) { users = users[i:j] },
+		func(i int) *TimelineCursor { return users[i].Cursor() })
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return users, older, newer, nil
+}
+
+// [/users/{id}/following]と[/users/{id}/followers]のハンドラ
+// followersがtrueならフォロワー一覧を表示する
+func (app *App) followsHandler(w http.ResponseWriter, r *http.Request, s *Session, id int64, followers bool) {
+	// GET以外は存在しない
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.FormValue("before"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+	after, err := parseTimelineCursor(r.FormValue("after"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+
+	// ユーザ情報の取得
+	fft := &FollowsForTemplate{ViewerID: uid, Followers: followers}
+	exist, err := app.Users.FindByID(&fft.User, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if exist == false {
+		http.NotFound(w, r)
+		return
+	}
+
+	// 人数の取得
+	followingCount, followerCount, err := app.Followers.Count(id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	fft.Count = followingCount
+	if followers == true {
+		fft.Count = followerCount
+	}
+
+	// 一覧の取得
+	users, older, newer, err := app.Followers.Page(id, followers, uid, app.Config.UserSearchPageLimit, before, after)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	fft.Users = users
+	if older != nil {
+		fft.Older = older.String()
+	}
+	if newer != nil {
+		fft.Newer = newer.String()
+	}
+
+	err = responseTemplate.ExecuteTemplate(w, "follows.tmpl", fft)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+	}
 }
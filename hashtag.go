@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// TrendingTagsPeriod はトレンドのタグを集計する期間
+	TrendingTagsPeriod = 24 * time.Hour
+	// TrendingTagsLimit はトレンドとして表示するタグの数
+	TrendingTagsLimit = 10
+)
+
+// hashtagPattern は#tagの形式のハッシュタグ
+// 全角の＃も認める. 日本語の濁点などの結合文字もタグに含める
+// URLのフラグメントや文字参照を除くため, 直前が文字, 数字, &の場合はハッシュタグとしない
+// 日本語の文中でも直前に空白などが必要
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}\p{M}_#＃&])([#＃])([\p{L}\p{N}\p{M}_]+)`)
+
+// 数字のみのものはハッシュタグとしない
+var hashtagDigitsPattern = regexp.MustCompile(`^[\p{N}_]+$`)
+
+// Tag はハッシュタグ
+type Tag struct {
+	Name  string // 正規化したタグ名
+	Count int64  // トレンドの集計期間中に使われたsweetの数
+}
+
+// TagForTemplate はタグ一覧画面用のデータ構造
+type TagForTemplate struct {
+	Tag    Tag
+	Sweets []Post
+	Older  string // より古いページのカーソル. なければ空
+	Newer  string // より新しいページのカーソル. なければ空
+}
+
+// Path はタグのsweet一覧画面のパスを返す
+func (t *Tag) Path() string {
+	return tagPath(t.Name)
+}
+
+// normalizeTag はタグ名を比較用に正規化する
+// 全角英数字や半角カナを揃え, 英字は小文字にする
+func normalizeTag(name string) string {
+	return strings.ToLower(norm.NFKC.String(name))
+}
+
+// parseTags はmessage中のハッシュタグを正規化して出現順に重複なく返す
+func parseTags(message string) []string {
+	tags := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, m := range hashtagPattern.FindAllStringSubmatch(message, -1) {
+		if hashtagDigitsPattern.MatchString(m[2]) == true {
+			continue
+		}
+		tag := normalizeTag(m[2])
+		if seen[tag] == false {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// entryTags はpostIDのメッセージmessage中のハッシュタグをpost_tagsへ登録する
+// createdAtはトレンドの集計に使うsweetの投稿日時
+func entryTags(tx *Tx, postID int64, message string, createdAt time.Time) error {
+	for _, name := range parseTags(message) {
+		// 初めてのタグであれば登録
+		_, err := tx.Exec(tx.dialect.InsertIgnore("INSERT INTO tags(name) VALUES(?)"), name)
+		if err != nil {
+			return err
+		}
+		var tagID int64
+		err = tx.QueryRow("SELECT t.id FROM tags t WHERE t.name = ?", name).Scan(&tagID)
+		if err != nil {
+			return err
+		}
+
+		// 登録
+		_, err = tx.Exec("INSERT INTO post_tags(post_id, tag_id, created_at) VALUES(?, ?, ?)", postID, tagID, createdAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrendingTags はsince以降に投稿されたsweetで多く使われたタグをviewerIDに向けて最大limit件返す
+// 削除済のsweetと, タグの一覧と同じくviewerIDに表示出来ないsweet, ミュートしているユーザのsweetは数えない
+func (st *Store) TrendingTags(viewerID int64, since time.Time, limit int) ([]Tag, error) {
+	db := st.db
+	cond := (&sqlConds{}).add(`
+			pt.created_at >= ?`, since).visibleTo(viewerID).add(postMutedCond, viewerID)
+
+	// SQL発行
+	var args []interface{}
+	args = append(args, cond.args...)
+	args = append(args, limit)
+	rows, err := db.Query(`
+		SELECT
+			t.name,
+			COUNT(*) AS count
+		FROM`+postFromTables+`
+		INNER JOIN
+			post_tags pt
+		ON
+			pt.post_id = p.id
+		INNER JOIN
+			tags t
+		ON
+			t.id = pt.tag_id
+		WHERE`+cond.String()+`
+		GROUP BY
+			t.id, t.name
+		ORDER BY
+			count DESC, MAX(pt.created_at) DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]Tag, 0)
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.Name, &t.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// タグのsweet一覧をviewerIDが閲覧するSQLと引数を組み立てる
+// condはposts pに対する条件, orderはASCまたはDESC
+func taggedSweetsQuery(d Dialect, name string, viewerID int64, cond *sqlConds, order string, limit int) (string, []interface{}) {
+	cond.visibleTo(viewerID)
+	query := fmt.Sprintf(`
+		SELECT`+postSelectColumns(d)+`
+		FROM`+postFromTables+`
+		INNER JOIN
+			post_tags pt
+		ON
+			pt.post_id = p.id
+		INNER JOIN
+			tags t
+		ON
+			t.id = pt.tag_id
+		WHERE
+			t.name = ?
+		AND%[1]s
+		ORDER BY
+			p.created_at %[2]s, p.id %[2]s
+		LIMIT ?
+	`, cond, order)
+
+	// パラメータ組み立て
+	var args []interface{}
+	args = append(args, name)
+	args = append(args, cond.args...)
+	args = append(args, limit)
+	return query, args
+}
+
+// TagSweetsPage はタグnameの付いたSweetのうちviewerIDに表示出来るものの1ページ分を取得する
+// nameは正規化したもの. 引数と戻り値はTimelinePageと同じ
+func (st *Store) TagSweetsPage(name string, viewerID int64, limit int, before *TimelineCursor, after *TimelineCursor) ([]Post, *TimelineCursor, *TimelineCursor, error) {
+	return pageSweets(limit, before, after, (*Post).Cursor,
+		func(limit int, before *TimelineCursor) ([]Post, error) {
+			cond := (&sqlConds{}).olderThan("p.created_at", "p.id", before)
+			return st.queryPosts(taggedSweetsQuery(st.db.dialect, name, viewerID, cond, "DESC", limit))
+		},
+		func(limit int, after *TimelineCursor) ([]Post, error) {
+			cond := (&sqlConds{}).newerThan("p.created_at", "p.id", after)
+			posts, err := st.queryPosts(taggedSweetsQuery(st.db.dialect, name, viewerID, cond, "ASC", limit))
+			if err != nil {
+				return nil, err
+			}
+			reverseSlice(posts)
+			return posts, nil
+		})
+}
+
+// [/tags/{tag}]のパスからタグ名を取り出して正規化する
+func parseTagPath(path string) (string, bool) {
+	name := strings.Trim(strings.TrimPrefix(path, "/tags/"), "/")
+	if name == "" || strings.Contains(name, "/") == true {
+		return "", false
+	}
+	return normalizeTag(name), true
+}
+
+// [/tags/{tag}]のハンドラ
+func (app *App) tagHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は存在しない
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	name, ok := parseTagPath(r.URL.Path)
+	if ok == false {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.FormValue("before"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+	after, err := parseTimelineCursor(r.FormValue("after"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+
+	// タグの付いたsweetsの取得
+	posts, older, newer, err := app.TagSweetsPage(name, uid, app.Config.TimelinePageLimit, before, after)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if err := app.fillLikedByMe(uid, posts); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	tft := &TagForTemplate{Tag: Tag{Name: name}, Sweets: posts}
+	if older != nil {
+		tft.Older = older.String()
+	}
+	if newer != nil {
+		tft.Newer = newer.String()
+	}
+
+	err = responseTemplate.ExecuteTemplate(w, "tag.tmpl", tft)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+	}
+}
+
+// タグのsweet一覧画面のパス
+func tagPath(name string) string {
+	return "/tags/" + url.PathEscape(name)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		message string
+		want    []string
+	}{
+		{"#go", []string{"go"}},
+		{"今日は #晴れ #散歩", []string{"晴れ", "散歩"}},
+		{"(#go)", []string{"go"}},
+		// 全角の＃も認める
+		{"＃ゴー", []string{"ゴー"}},
+		// 正規化して重複を除く
+		{"#Go #GO #ｇｏ", []string{"go"}},
+		// 半角カナと結合文字の濁点
+		{"#ｶﾞｰﾃﾞﾝ", []string{"ガーデン"}},
+		{"#か\u3099", []string{"が"}},
+		// 数字のみのものはタグとしない
+		{"#123", []string{}},
+		{"#2024年", []string{"2024年"}},
+		// URLのフラグメントと文字参照はタグとしない
+		{"example.com/page#section", []string{}},
+		{"&#39;quote&#39;", []string{}},
+		// 日本語の文中では直前に空白などが必要
+		{"日本語#タグ", []string{}},
+		{"日本語 #タグ", []string{"タグ"}},
+		{"日本語　#タグ", []string{"タグ"}},
+		// 続けて書いたタグは最初のもののみ
+		{"#a#b", []string{"a"}},
+		{"##go", []string{}},
+		{"# go", []string{}},
+		{"", []string{}},
+	}
+	for _, tt := range tests {
+		if got := parseTags(tt.message); reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("parseTags(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Go", "go"},
+		{"ＧＯ", "go"},
+		{"ｇｏ１２３", "go123"},
+		{"ｶﾀｶﾅ", "カタカナ"},
+		{"が", "が"},
+		{"か\u3099", "が"},
+		{"日本語", "日本語"},
+	}
+	for _, tt := range tests {
+		if got := normalizeTag(tt.name); got != tt.want {
+			t.Errorf("normalizeTag(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// トレンドには閲覧者に表示出来ないsweetとミュートしているユーザのsweetのタグを数えない
+func TestTrendingTagsHidesInvisibleSweets(t *testing.T) {
+	st := newTestStore(t)
+	alice := newTestUser(t, st, "alice")
+	bob := newTestUser(t, st, "bob")
+	carol := newTestUser(t, st, "carol")
+	dave := newTestUser(t, st, "dave")
+	erin := newTestUser(t, st, "erin")
+	newTestFollow(t, st, dave.ID, alice.ID)
+	if err := st.setProtected(alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		newTestPost(t, st, alice.ID, "#鍵")
+	}
+	for i := 0; i < 2; i++ {
+		newTestPost(t, st, bob.ID, "#ブロック")
+		newTestPost(t, st, carol.ID, "#ミュート")
+	}
+	newTestPost(t, st, dave.ID, "#公開")
+	if err := (&Block{UserID: erin.ID, TargetID: bob.ID}).Entry(st); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Mute{UserID: erin.ID, TargetID: carol.ID}).Entry(st); err != nil {
+		t.Fatal(err)
+	}
+
+	since := time.Now().Add(-time.Hour)
+	tags, err := st.TrendingTags(erin.ID, since, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Tag{{Name: "公開", Count: 1}}; reflect.DeepEqual(tags, want) == false {
+		t.Errorf("TrendingTags(erin) = %+v, want %+v", tags, want)
+	}
+
+	// フォロワーには鍵アカウントのタグも数える
+	tags, err = st.TrendingTags(dave.ID, since, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 4 || tags[0] != (Tag{Name: "鍵", Count: 3}) {
+		t.Errorf("TrendingTags(dave) = %+v", tags)
+	}
+}
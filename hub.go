@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sync"
+)
+
+// HubSubscriberBuffer は購読者ごとに溜めておけるイベントの数
+// これを超えて受け取れない購読者は購読を打ち切る
+const HubSubscriberBuffer = 64
+
+// イベントの種類
+const (
+	// HubEventSweet はsweetが投稿されたイベント
+	HubEventSweet = "sweet"
+	// HubEventLike はsweetがいいねされたイベント
+	HubEventLike = "like"
+	// HubEventFollow はユーザがフォローされたイベント
+	HubEventFollow = "follow"
+	// HubEventNotification は通知が登録されたイベント
+	HubEventNotification = "notification"
+)
+
+// HubEvent はHubで配信するイベント
+// 購読者は必要に応じてIDなどからDBの最新の内容を取得する
+type HubEvent struct {
+	Type           string // イベントの種類. HubEventSweetなど
+	UserID         int64  // 操作したユーザのID
+	TargetUserID   int64  // フォローされた, いいねされた, 通知を受け取るユーザのID. なければ0
+	PostID         int64  // 対象のsweetのID. なければ0
+	NotificationID int64  // 通知のID. なければ0
+}
+
+// Hub はプロセス内のイベントの配信を行う
+// 複数のプロセスで動かすと他のプロセスのイベントは届かない
+type Hub struct {
+	subscribers map[*HubSubscription]bool
+	closed      bool
+	lock        sync.Mutex
+}
+
+// HubSubscription はHubの購読
+// 配信が追いつかずに購読を打ち切られるとCが閉じられる
+type HubSubscription struct {
+	C   chan HubEvent
+	hub *Hub
+}
+
+// NewHub は購読者のいないHubを生成して返す
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[*HubSubscription]bool)}
+}
+
+// Subscribe はイベントの購読を始める
+// 使い終わったらUnsubscribeを呼ぶ
+func (h *Hub) Subscribe() *HubSubscription {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	sub := &HubSubscription{C: make(chan HubEvent, HubSubscriberBuffer), hub: h}
+	if h.closed == true {
+		// 停止後は何も配信しない
+		close(sub.C)
+		return sub
+	}
+	h.subscribers[sub] = true
+	return sub
+}
+
+// Close は全ての購読を打ち切り, 以降の購読もすぐに打ち切る
+// サーバの停止時に購読しているストリームを終わらせるために使う
+func (h *Hub) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.C)
+	}
+}
+
+// Closed はCloseを呼んでいればtrueを返す
+func (h *Hub) Closed() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.closed
+}
+
+// Publish はeventsを順に全ての購読者へ配信する
+// 配信を待たずに戻り, 受け取れない購読者は購読を打ち切る
+func (h *Hub) Publish(events ...HubEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for _, e := range events {
+		for sub := range h.subscribers {
+			select {
+			case sub.C <- e:
+			default:
+				// 遅い購読者で他の購読者を待たせない
+				delete(h.subscribers, sub)
+				close(sub.C)
+			}
+		}
+	}
+}
+
+// Unsubscribe は購読をやめる. 打ち切られた後に呼んでもよい
+func (sub *HubSubscription) Unsubscribe() {
+	h := sub.hub
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.subscribers[sub] == true {
+		delete(h.subscribers, sub)
+		close(sub.C)
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Like はsweetへのいいね
+type Like struct {
+	UserID   int64
+	PostID   int64
+	Messages []string // エラーメッセージ
+}
+
+// LikesForTemplate はいいね一覧画面用のデータ構造
+type LikesForTemplate struct {
+	User   User
+	Hidden bool // 鍵アカウントで閲覧者がいいねを閲覧出来なければtrue
+	Sweets []Post
+	Older  string // より古いページのカーソル. なければ空
+	Newer  string // より新しいページのカーソル. なければ空
+}
+
+// Validate はLikeの登録前の入力チェックを行う
+func (l *Like) Validate(st *Store) error {
+	var messages []string
+
+	// いいねするsweetの存在チェック
+	// リスイートにはいいねせずリスイート元にいいねする
+	var p Post
+	exist, err := st.Posts.FindByID(&p, l.PostID)
+	if err != nil {
+		return err
+	}
+	// 表示出来ないユーザのsweetは存在しないものとして扱う
+	visible := false
+	if exist == true {
+		visible, err = st.isVisibleUser(l.UserID, p.UserID)
+		if err != nil {
+			return err
+		}
+	}
+	if exist == false || p.Deleted == true || p.IsResweet() == true || visible == false {
+		messages = append(messages, "いいねするすいーとは存在しません")
+	}
+
+	l.Messages = messages
+	return nil
+}
+
+// Entry はLikeの情報登録を行い, sweetの投稿者へ通知する
+// 既にいいねしている場合は何もしない
+func (l *Like) Entry(st *Store) error {
+	db := st.db
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 登録
+	result, err := tx.Exec(tx.dialect.InsertIgnore("INSERT INTO likes(user_id, post_id, created_at) VALUES(?, ?, ?)"), l.UserID, l.PostID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// 新たにいいねした場合は投稿者へ通知
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return tx.Commit()
+	}
+	var postUserID int64
+	err = tx.QueryRow("SELECT p.user_id FROM posts p WHERE p.id = ?", l.PostID).Scan(&postUserID)
+	if err != nil {
+		return err
+	}
+	events := []HubEvent{{Type: HubEventLike, UserID: l.UserID, TargetUserID: postUserID, PostID: l.PostID}}
+	e, err := entryNotification(tx, postUserID, l.UserID, NotificationLike, l.PostID)
+	if err != nil {
+		return err
+	}
+	if e != nil {
+		events = append(events, *e)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// 購読者へ配信
+	st.Hub.Publish(events...)
+	return nil
+}
+
+// Remove はLikeの情報削除を行う
+func (l *Like) Remove(st *Store) error {
+	db := st.db
+
+	// プリペアードステートメント生成
+	stmt, err := db.Prepare(`
+		DELETE
+		FROM
+			likes
+		WHERE
+			user_id = ?
+		AND
+			post_id = ?
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// クエリ発行
+	_, err = stmt.Exec(l.UserID, l.PostID)
+	return err
+}
+
+// userIDがpostIDにいいねしていればtrueを返す
+func (st *Store) isLiked(userID int64, postID int64) (bool, error) {
+	liked, err := st.likedPostIDs(userID, []int64{postID})
+	if err != nil {
+		return false, err
+	}
+	return liked[postID], nil
+}
+
+// idsのうちuserIDがいいねしているPostのIDを返す
+func (st *Store) likedPostIDs(userID int64, ids []int64) (map[int64]bool, error) {
+	liked := make(map[int64]bool)
+	if len(ids) == 0 {
+		return liked, nil
+	}
+
+	db := st.db
+	// パラメータ組み立て
+	placeholders := make([]string, 0, len(ids))
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, userID)
+	for _, id := range ids {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+
+	// SQL発行
+	rows, err := db.Query(`
+		SELECT
+			l.post_id
+		FROM
+			likes l
+		WHERE
+			l.user_id = ?
+		AND
+			l.post_id IN (`+strings.Join(placeholders, ", ")+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		liked[id] = true
+	}
+	return liked, rows.Err()
+}
+
+// fillLikedByMe はpostsとそのリスイート元のLikedByMeをuserIDについて設定する
+// 1回のクエリでまとめて取得する
+func (st *Store) fillLikedByMe(userID int64, posts []Post) error {
+	ids := make([]int64, 0, len(posts))
+	for _, p := range posts {
+		ids = append(ids, p.ID)
+		if p.Original != nil {
+			ids = append(ids, p.Original.ID)
+		}
+	}
+	liked, err := st.likedPostIDs(userID, ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].LikedByMe = liked[posts[i].ID]
+		if posts[i].Original != nil {
+			posts[i].Original.LikedByMe = liked[posts[i].Original.ID]
+		}
+	}
+	return nil
+}
+
+// いいね一覧をviewerIDが閲覧するSQLと引数を組み立てる
+// condはlikes lに対する条件, orderはASCまたはDESC
+func likedSweetsQuery(d Dialect, userID int64, viewerID int64, cond *sqlConds, order string, limit int) (string, []interface{}) {
+	cond.visibleTo(viewerID)
+	query := fmt.Sprintf(`
+		SELECT`+postSelectColumns(d)+`,
+			l.created_at AS liked_at
+		FROM`+postFromTables+`
+		INNER JOIN
+			likes l
+		ON
+			l.post_id = p.id
+		WHERE
+			l.user_id = ?
+		AND%[1]s
+		ORDER BY
+			l.created_at %[2]s, l.post_id %[2]s
+		LIMIT ?
+	`, cond, order)
+
+	// パラメータ組み立て
+	var args []interface{}
+	args = append(args, userID)
+	args = append(args, cond.args...)
+	args = append(args, limit)
+	return query, args
+}
+
+// likedSweetsQueryで組み立てたSQLを発行していいね一覧を取得する
+func (st *Store) queryLikedSweets(query string, args []interface{}) ([]Post, error) {
+	db := st.db
+
+	// SQL発行
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPostsWith(rows, func(p *Post) []interface{} {
+		return []interface{}{&p.LikedAt}
+	})
+}
+
+// LikedSweetsPage はuserIDがいいねしたSweetのうちviewerIDに表示出来るものの1ページ分をいいねした新しい順に取得する
+// カーソルはいいねした日時とPostのIDの組. 引数と戻り値はTimelinePageと同じ
+func (st *Store) LikedSweetsPage(userID int64, viewerID int64, limit int, before *TimelineCursor, after *TimelineCursor) ([]Post, *TimelineCursor, *TimelineCursor, error) {
+	return pageSweets(limit, before, after, (*Post).LikeCursor,
+		func(limit int, before *TimelineCursor) ([]Post, error) {
+			cond := (&sqlConds{}).olderThan("l.created_at", "l.post_id", before)
+			return st.queryLikedSweets(likedSweetsQuery(st.db.dialect, userID, viewerID, cond, "DESC", limit))
+		},
+		func(limit int, after *TimelineCursor) ([]Post, error) {
+			cond := (&sqlConds{}).newerThan("l.created_at", "l.post_id", after)
+			posts, err := st.queryLikedSweets(likedSweetsQuery(st.db.dialect, userID, viewerID, cond, "ASC", limit))
+			if err != nil {
+				return nil, err
+			}
+			reverseSlice(posts)
+			return posts, nil
+		})
+}
+
+// [/users/{id}/likes]のハンドラ
+func (app *App) likesHandler(w http.ResponseWriter, r *http.Request, s *Session, id int64) {
+	// GET以外は存在しない
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.FormValue("before"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+	after, err := parseTimelineCursor(r.FormValue("after"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+
+	// ユーザ情報の取得
+	lft := &LikesForTemplate{}
+	exist, err := app.Users.FindByID(&lft.User, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if exist == false {
+		http.NotFound(w, r)
+		return
+	}
+
+	// 鍵アカウントのいいねは承認したフォロワーにのみ表示する
+	visible, err := app.canViewSweetsOf(uid, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if visible == false {
+		lft.Hidden = true
+		err = responseTemplate.ExecuteTemplate(w, "likes.tmpl", lft)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// いいねしたsweetsの取得
+	posts, older, newer, err := app.LikedSweetsPage(id, uid, app.Config.TimelinePageLimit, before, after)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if err := app.fillLikedByMe(uid, posts); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	lft.Sweets = posts
+	if older != nil {
+		lft.Older = older.String()
+	}
+	if newer != nil {
+		lft.Newer = newer.String()
+	}
+
+	err = responseTemplate.ExecuteTemplate(w, "likes.tmpl", lft)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+	}
+}
+
+// [/like]のハンドラ
+func (app *App) likeHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は存在しない
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// いいねするsweetを取得
+	postID, err := strconv.ParseInt(r.FormValue("post_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+
+	// 入力チェック
+	l := &Like{UserID: uid, PostID: postID}
+	if err := l.Validate(app.Store); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if len(l.Messages) > 0 {
+		http.NotFound(w, r)
+		return
+	}
+	// 登録
+	if err := l.Entry(app.Store); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// 指定がなければsweetのスレッドへ回す
+	redirectBack(w, r, threadPath(postID))
+}
+
+// [/unlike]のハンドラ
+func (app *App) unlikeHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は存在しない
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// いいねを取り消すsweetを取得
+	postID, err := strconv.ParseInt(r.FormValue("post_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+
+	// いいねを削除
+	l := &Like{UserID: uid, PostID: postID}
+	if err := l.Remove(app.Store); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// 指定がなければsweetのスレッドへ回す
+	redirectBack(w, r, threadPath(postID))
+}
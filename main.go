@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"html/template"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"syscall"
+	"time"
 )
 
 // 各ページのテンプレート入りテンプレート
@@ -15,14 +22,19 @@ var responseTemplate *template.Template
 // セッションマネージャ
 var sessionManager *SessionManager
 
-const (
-	// SessionUserIDKey はSessionManagerのSession内におけるUserIDのキー
-	SessionUserIDKey = "UserID"
-	// TimelinePageLimit は1ページあたりのsweetの表示件数
-	TimelinePageLimit = 50
-	// UserSearchPageLimit は1ページあたりのユーザ表示件数
-	UserSearchPageLimit = 50
-)
+// App はハンドラが使うStoreと設定を保持する
+// StoreのメソッドとリポジトリはAppから直接呼び出せる
+type App struct {
+	*Store
+	Config *Config
+
+	webSockets       sync.WaitGroup // 接続中のWebSocket
+	webSocketsMu     sync.Mutex     // webSocketsClosedとwebSockets.Addを守る
+	webSocketsClosed bool           // 停止を始めていればtrue
+}
+
+// SessionUserIDKey はSessionManagerのSession内におけるUserIDのキー
+const SessionUserIDKey = "UserID"
 
 // テンプレートファイルを読み込む
 func loadTemplates(dir string) (*template.Template, error) {
@@ -41,48 +53,189 @@ func loadTemplates(dir string) (*template.Template, error) {
 	return template.ParseFiles(filepaths...)
 }
 
-func init() {
-	var err error
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// サーバまたはサブコマンドを実行する. argsはプログラム名を除いたコマンドライン引数
+// 途中で失敗してもDBの接続などをdeferで閉じてからエラーを返す
+func run(args []string) error {
+	// アプリケーション設定の読み込み
+	config, args, err := loadConfig(args)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// マイグレーションのサブコマンド
+	if len(args) > 0 && args[0] == "migrate" {
+		return runMigrate(config, args[1:], os.Stdout)
+	}
 
 	// テンプレート初期化
-	responseTemplate, err = loadTemplates("./templates")
+	if err := config.ValidateServeDirs(); err != nil {
+		return err
+	}
+	responseTemplate, err = loadTemplates(config.TemplateDir)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	// DBへの接続とsweet検索の初期化
+	store, err := OpenStore(config)
+	if err != nil {
+		return err
 	}
+	defer store.Close()
+	app := &App{Store: store, Config: config}
 
 	// セッションマネージャ初期化
-	sessionManager, err = NewSessionManager("suitter", 86400)
+	sessionStore, err := newSessionStore(config, store)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	sessionManager, err = NewSessionManager(config.SessionCookieName, config.SessionMaxAge, sessionStore)
+	if err != nil {
+		return err
 	}
 	sessionManager.GC()
+	defer sessionManager.StopGC()
+
+	http.Handle("/", http.FileServer(http.Dir(config.StaticDir)))
+	http.HandleFunc("/login", unneedLogin(app.loginHandler))
+	http.HandleFunc("/logout", app.needLogin(logoutHandler))
+	http.HandleFunc("/signup", unneedLogin(app.signupHandler))
+	http.HandleFunc("/timeline", app.needLogin(app.timelineHandler))
+	http.HandleFunc("/timeline/stream", app.needLogin(app.timelineStreamHandler))
+	http.HandleFunc("/sweets", app.needLogin(app.sweetsHandler))
+	http.HandleFunc("/sweets/", app.needLogin(app.sweetHandler))
+	http.HandleFunc("/followers", app.needLogin(legacyUserSearchHandler))
+	http.HandleFunc("/follow", app.needLogin(app.followHandler))
+	http.HandleFunc("/unfollow", app.needLogin(app.unfollowHandler))
+	http.HandleFunc("/resweet", app.needLogin(app.resweetHandler))
+	http.HandleFunc("/unresweet", app.needLogin(app.unresweetHandler))
+	http.HandleFunc("/like", app.needLogin(app.likeHandler))
+	http.HandleFunc("/unlike", app.needLogin(app.unlikeHandler))
+	http.HandleFunc("/users", app.needLogin(app.userSearchHandler))
+	http.HandleFunc("/users/", app.needLogin(app.usersHandler))
+	http.HandleFunc("/notifications", app.needLogin(app.notificationsHandler))
+	http.HandleFunc("/mentions", app.needLogin(app.mentionsHandler))
+	http.HandleFunc("/tags/", app.needLogin(app.tagHandler))
+	http.HandleFunc("/search", app.needLogin(app.searchHandler))
+	http.HandleFunc("/follow_requests", app.needLogin(app.followRequestsHandler))
+	http.HandleFunc("/follow_requests/approve", app.needLogin(app.approveFollowRequestHandler))
+	http.HandleFunc("/follow_requests/reject", app.needLogin(app.rejectFollowRequestHandler))
+	http.HandleFunc("/protect", app.needLogin(app.protectHandler))
+	http.HandleFunc("/unprotect", app.needLogin(app.unprotectHandler))
+	http.HandleFunc("/blocks", app.needLogin(app.blocksHandler))
+	http.HandleFunc("/block", app.needLogin(app.blockHandler))
+	http.HandleFunc("/unblock", app.needLogin(app.unblockHandler))
+	http.HandleFunc("/mute", app.needLogin(app.muteHandler))
+	http.HandleFunc("/unmute", app.needLogin(app.unmuteHandler))
+	http.HandleFunc("/tokens", app.needLogin(app.tokensHandler))
+	http.HandleFunc("/tokens/revoke", app.needLogin(app.revokeTokenHandler))
+
+	// JSON API
+	http.HandleFunc(APIPathPrefix+"/me", app.needAPILogin(app.apiMeHandler))
+	http.HandleFunc(APIPathPrefix+"/timeline", app.needAPILogin(app.apiTimelineHandler))
+	http.HandleFunc(APIPathPrefix+"/ws", app.needAPILogin(app.apiWebSocketHandler))
+	http.HandleFunc(APIPathPrefix+"/notifications", app.needAPILogin(app.apiNotificationsHandler))
+	http.HandleFunc(APIPathPrefix+"/notifications/read", app.needAPILogin(app.apiReadNotificationsHandler))
+	http.HandleFunc(APIPathPrefix+"/mentions", app.needAPILogin(app.apiMentionsHandler))
+	http.HandleFunc(APIPathPrefix+"/tags/", app.needAPILogin(app.apiTagHandler))
+	http.HandleFunc(APIPathPrefix+"/trends", app.needAPILogin(app.apiTrendsHandler))
+	http.HandleFunc(APIPathPrefix+"/search", app.needAPILogin(app.apiSearchHandler))
+	http.HandleFunc(APIPathPrefix+"/sweets", app.needAPILogin(app.apiSweetsHandler))
+	http.HandleFunc(APIPathPrefix+"/sweets/", app.needAPILogin(app.apiSweetHandler))
+	http.HandleFunc(APIPathPrefix+"/users", app.needAPILogin(app.apiUsersHandler))
+	http.HandleFunc(APIPathPrefix+"/users/", app.needAPILogin(app.apiUserHandler))
+	http.HandleFunc(APIPathPrefix+"/follow", app.needAPILogin(app.apiFollowHandler))
+	http.HandleFunc(APIPathPrefix+"/unfollow", app.needAPILogin(app.apiUnfollowHandler))
+	http.HandleFunc(APIPathPrefix+"/resweet", app.needAPILogin(app.apiResweetHandler))
+	http.HandleFunc(APIPathPrefix+"/unresweet", app.needAPILogin(app.apiUnresweetHandler))
+	http.HandleFunc(APIPathPrefix+"/like", app.needAPILogin(app.apiLikeHandler))
+	http.HandleFunc(APIPathPrefix+"/unlike", app.needAPILogin(app.apiUnlikeHandler))
+	http.HandleFunc(APIPathPrefix+"/follow_requests", app.needAPILogin(app.apiFollowRequestsHandler))
+	http.HandleFunc(APIPathPrefix+"/follow_requests/approve", app.needAPILogin(app.apiApproveFollowRequestHandler))
+	http.HandleFunc(APIPathPrefix+"/follow_requests/reject", app.needAPILogin(app.apiRejectFollowRequestHandler))
+	http.HandleFunc(APIPathPrefix+"/blocks", app.needAPILogin(app.apiBlocksHandler))
+	http.HandleFunc(APIPathPrefix+"/block", app.needAPILogin(app.apiBlockHandler))
+	http.HandleFunc(APIPathPrefix+"/unblock", app.needAPILogin(app.apiUnblockHandler))
+	http.HandleFunc(APIPathPrefix+"/mute", app.needAPILogin(app.apiMuteHandler))
+	http.HandleFunc(APIPathPrefix+"/unmute", app.needAPILogin(app.apiUnmuteHandler))
+	http.HandleFunc(APIPathPrefix+"/tokens", app.needAPILogin(app.apiTokensHandler))
+	http.HandleFunc(APIPathPrefix+"/tokens/revoke", app.needAPILogin(app.apiRevokeTokenHandler))
+
+	srv := &http.Server{
+		Addr:              config.Listen,
+		ReadHeaderTimeout: time.Duration(config.ReadTimeout) * time.Second,
+		ReadTimeout:       time.Duration(config.ReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(config.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(config.IdleTimeout) * time.Second,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+	// 停止時はストリームとWebSocketの購読を打ち切って終わらせる
+	srv.RegisterOnShutdown(store.Hub.Close)
+
+	// SIGINT, SIGTERMを受けたら新しい接続を断り, 処理中のリクエストを待ってから停止する
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		log.Println("Shutting down by", <-sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout)*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+		app.waitWebSockets(ctx)
+	}()
 
-	// アプリケーション設定の読み込み
-	applicationConfig, err = loadConfig("./")
-	if err != nil {
-		log.Fatal(err)
+	log.Println("Booting up " + config.Listen)
+	err = srv.ListenAndServe()
+	if err != http.ErrServerClosed {
+		return err
 	}
+	<-stopped
+
+	// DBの接続とセッションのGCはdeferで止める
+	log.Println("Stopped")
+	return nil
 }
 
-func main() {
-	var err error
-	var port string
-	port = ":80"
-
-	http.Handle("/", http.FileServer(http.Dir("./static")))
-	http.HandleFunc("/login", unneedLogin(loginHandler))
-	http.HandleFunc("/logout", needLogin(logoutHandler))
-	http.HandleFunc("/signup", unneedLogin(signupHandler))
-	http.HandleFunc("/timeline", needLogin(timelineHandler))
-	http.HandleFunc("/sweets", needLogin(sweetsHandler))
-	http.HandleFunc("/followers", needLogin(followersHandler))
-	http.HandleFunc("/follow", needLogin(followHandler))
-	http.HandleFunc("/unfollow", needLogin(unfollowHandler))
-
-	log.Println("Booting up localhost" + port)
-	err = http.ListenAndServe(port, nil)
-	if err != nil {
-		log.Fatal(err)
+// 接続するWebSocketを数える. 停止を始めていれば数えずにfalseを返す
+// Waitを始めた後にAddしないよう, waitWebSocketsと同じロックの下で行う
+func (app *App) addWebSocket() bool {
+	app.webSocketsMu.Lock()
+	defer app.webSocketsMu.Unlock()
+	if app.webSocketsClosed == true {
+		return false
+	}
+	app.webSockets.Add(1)
+	return true
+}
+
+// 新しいWebSocketを断り, 接続中のWebSocketが全て終わるかctxの期限まで待つ
+func (app *App) waitWebSockets(ctx context.Context) {
+	app.webSocketsMu.Lock()
+	app.webSocketsClosed = true
+	app.webSocketsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		app.webSockets.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Println(ctx.Err())
 	}
 }
 
@@ -90,10 +243,16 @@ func main() {
 type HandlerFuncWithSession func(http.ResponseWriter, *http.Request, *Session)
 
 // 認証処理
-func needLogin(fn HandlerFuncWithSession) http.HandlerFunc {
+// CookieのセッションまたはAuthorizationヘッダのBearerトークンで認証する
+func (app *App) needLogin(fn HandlerFuncWithSession) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// 認証処理
-		if ok, s, err := sessionManager.IsSessionStarted(w, r); ok == true {
+		if ok, s, err := app.loginSession(w, r); ok == true {
+			// トークンのスコープ外の操作は拒否
+			if tokenScopeAllows(s, r) == false {
+				http.Error(w, "Forbidden.", http.StatusForbidden)
+				return
+			}
 			fn(w, r, s)
 		} else {
 			// errorがあればロギング
@@ -123,7 +282,7 @@ func unneedLogin(fn HandlerFuncWithSession) http.HandlerFunc {
 }
 
 // [/sweets]処理用のハンドラ
-func sweetsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+func (app *App) sweetsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 	switch r.Method {
 	case "POST":
 		// 認証したユーザのIDを取得
@@ -149,16 +308,53 @@ func sweetsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 			UserID:  uid,
 			Message: r.PostFormValue("message"),
 		}
+		// 返信先, 引用元があれば設定
+		if v := r.PostFormValue("in_reply_to"); v != "" {
+			post.InReplyTo, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, "Bad Request.", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := r.PostFormValue("resweet_of"); v != "" {
+			post.ResweetOf, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, "Bad Request.", http.StatusBadRequest)
+				return
+			}
+		}
 		// 入力チェック
-		if err := post.Validate(); err != nil {
+		if err := post.Validate(app.Store); err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+			return
+		}
+		if len(post.Messages) > 0 && (post.InReplyTo != 0 || post.ResweetOf != 0) {
+			// 返信先, 引用元があればスレッドの入力フォームを再表示
+			threadID := post.InReplyTo
+			if threadID == 0 {
+				threadID = post.ResweetOf
+			}
+			t, exist, err := app.findThread(threadID)
+			if err != nil {
+				log.Println(err)
+				http.Error(w, "Sorry.", http.StatusInternalServerError)
+				return
+			}
+			if exist == true {
+				app.renderThread(w, t, uid, post.Messages)
+				return
+			}
+		}
+		if len(post.Messages) > 0 {
 			// sweetsの取得
-			posts, err := Sweets(uid, TimelinePageLimit, 0)
+			timeline, err := app.newTimelineForTemplate(uid, nil, nil)
 			if err != nil {
-				log.Println("user_id type assertion fail")
+				log.Println(err)
 				http.Error(w, "Sorry.", http.StatusInternalServerError)
 				return
 			}
-			timeline := &TimelineForTemplate{Sweets: posts}
+			timeline.Messages = post.Messages
 			// 入力エラーがあればtimelineの入力フォームを再表示
 			err = responseTemplate.ExecuteTemplate(w, "timeline.tmpl", timeline)
 			if err != nil {
@@ -168,21 +364,25 @@ func sweetsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 			return
 		}
 		// 登録
-		if err := post.Entry(); err != nil {
+		if err := app.Posts.Entry(post); err != nil {
 			if err != nil {
 				log.Println(err)
 				http.Error(w, "Sorry.", http.StatusInternalServerError)
 				return
 			}
 		}
+		// 返信はスレッドへ戻す
+		if post.InReplyTo != 0 {
+			http.Redirect(w, r, threadPath(post.InReplyTo), http.StatusFound)
+			return
+		}
 		// sweetsの取得
-		posts, err := Sweets(uid, TimelinePageLimit, 0)
+		timeline, err := app.newTimelineForTemplate(uid, nil, nil)
 		if err != nil {
-			log.Println("user_id type assertion fail")
+			log.Println(err)
 			http.Error(w, "Sorry.", http.StatusInternalServerError)
 			return
 		}
-		timeline := &TimelineForTemplate{Sweets: posts}
 		// timelineの表示
 		err = responseTemplate.ExecuteTemplate(w, "timeline.tmpl", timeline)
 		if err != nil {
@@ -195,7 +395,7 @@ func sweetsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 }
 
 // [/timeline]処理用のハンドラ
-func timelineHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+func (app *App) timelineHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 	// GET以外は存在しない
 	if r.Method != "GET" {
 		http.NotFound(w, r)
@@ -215,17 +415,26 @@ func timelineHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 		return
 	}
 
-	// sweetsの取得
-	posts, err := Sweets(uid, TimelinePageLimit, 0)
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.FormValue("before"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+	after, err := parseTimelineCursor(r.FormValue("after"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+
+	// sweetsの取得と表示用データの作成
+	timeline, err := app.newTimelineForTemplate(uid, before, after)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Sorry.", http.StatusInternalServerError)
 		return
 	}
 
-	// 表示用データの作成
-	timeline := &TimelineForTemplate{Sweets: posts}
-
 	err = responseTemplate.ExecuteTemplate(w, "timeline.tmpl", timeline)
 	if err != nil {
 		log.Println(err)
@@ -234,7 +443,7 @@ func timelineHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 }
 
 // [/login]処理用のハンドラ
-func loginHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+func (app *App) loginHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 	switch r.Method {
 	case "GET":
 		err := responseTemplate.ExecuteTemplate(w, "login.tmpl", nil)
@@ -254,7 +463,7 @@ func loginHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 			Email:    r.PostFormValue("email"),
 			Password: r.PostFormValue("password"),
 		}
-		ok, err := u.Authenticate()
+		ok, err := u.Authenticate(app.Users)
 		if err != nil {
 			log.Println(err)
 			http.Error(w, "Sorry.", http.StatusInternalServerError)
@@ -308,7 +517,7 @@ func logoutHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 }
 
 // [/signup]処理用のハンドラ
-func signupHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+func (app *App) signupHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 	switch r.Method {
 	case "GET":
 		// ログイン済みならタイムラインへリダイレクトする
@@ -340,7 +549,7 @@ func signupHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 			ConfirmPassword: r.PostFormValue("confirm_password"),
 		}
 		// 検証
-		err := u.Validate()
+		err := u.Validate(app.Users)
 		if err != nil {
 			log.Println(err)
 			http.Error(w, "Sorry.", http.StatusInternalServerError)
@@ -357,7 +566,7 @@ func signupHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 			return
 		}
 		// 登録
-		if err := u.Entry(); err != nil {
+		if err := app.Users.Entry(u); err != nil {
 			log.Println(err)
 			http.Error(w, "Sorry.", http.StatusInternalServerError)
 			return
@@ -383,7 +592,7 @@ func signupHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 }
 
 // [/unfollow]のハンドラ
-func unfollowHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+func (app *App) unfollowHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 	// POST以外は存在しない
 	if r.Method != "POST" {
 		http.NotFound(w, r)
@@ -407,25 +616,25 @@ func unfollowHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 	unfollowUserIDStr := r.FormValue("unfollow_user_id")
 	unfollowUserID, err := strconv.ParseInt(unfollowUserIDStr, 10, 64)
 	if err != nil {
-		log.Println("unfollow_user_id convert error")
-		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
 		return
 	}
 
 	// フォロー情報を削除
-	f := Follower{UserID: uid, FollowerID: unfollowUserID}
-	if err := f.Remove(); err != nil {
-		log.Println("follower Remove error")
+	// フォローしていなくても成功とする
+	f := Follow{FollowerID: uid, FolloweeID: unfollowUserID}
+	if err := app.Followers.Remove(&f); err != nil {
+		log.Println(err)
 		http.Error(w, "Sorry.", http.StatusInternalServerError)
 		return
 	}
 
-	// タイムラインへ回す
-	http.Redirect(w, r, "/followers", http.StatusFound)
+	// 指定がなければユーザ検索へ回す
+	redirectBack(w, r, "/users")
 }
 
 // [/follow]のハンドラ
-func followHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+func (app *App) followHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 	// POST以外は存在しない
 	if r.Method != "POST" {
 		http.NotFound(w, r)
@@ -449,42 +658,57 @@ func followHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 	followUserIDStr := r.FormValue("follow_user_id")
 	followUserID, err := strconv.ParseInt(followUserIDStr, 10, 64)
 	if err != nil {
-		log.Println("follow_user_id convert error")
-		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
 		return
 	}
 
 	// 登録前チェック
-	f := Follower{UserID: uid, FollowerID: followUserID}
-	if err := f.Validate(); err != nil {
-		log.Println("follower validate error")
+	f := Follow{FollowerID: uid, FolloweeID: followUserID}
+	if err := f.Validate(app.Store); err != nil {
+		log.Println(err)
 		http.Error(w, "Sorry.", http.StatusInternalServerError)
 		return
 	} else if len(f.Messages) > 0 {
 		// sweetsの取得
-		posts, err := Sweets(uid, TimelinePageLimit, 0)
+		timeline, err := app.newTimelineForTemplate(uid, nil, nil)
 		if err != nil {
-			log.Println("user_id type assertion fail")
+			log.Println(err)
 			http.Error(w, "Sorry.", http.StatusInternalServerError)
 			return
 		}
-		timeline := &TimelineForTemplate{Sweets: posts, Messages: f.Messages}
-		// 入力エラーがあればtimelineの入力フォームを再表示
+		timeline.Messages = f.Messages
+		// 入力エラーがあればtimelineの入力フォームを再表示して登録しない
 		err = responseTemplate.ExecuteTemplate(w, "timeline.tmpl", timeline)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+		}
+		return
 	}
 
 	// フォロー情報を登録
-	if err := f.Entry(); err != nil {
-		log.Println("follower Entry error")
+	// 既にフォローしていても成功とする
+	if err := app.Followers.Entry(&f); err != nil {
+		log.Println(err)
 		http.Error(w, "Sorry.", http.StatusInternalServerError)
 		return
 	}
-	// ユーザ検索へ回す
-	http.Redirect(w, r, "/followers", http.StatusFound)
+	// 指定がなければユーザ検索へ回す
+	redirectBack(w, r, "/users")
 }
 
 // [/followers]のハンドラ
-func followersHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+// ユーザ検索は[/users]へ移ったのでリダイレクトする
+func legacyUserSearchHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	to := "/users"
+	if r.URL.RawQuery != "" {
+		to += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, to, http.StatusMovedPermanently)
+}
+
+// [/users]のハンドラ
+func (app *App) userSearchHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 	// GET以外は存在しない
 	if r.Method != "GET" {
 		http.NotFound(w, r)
@@ -512,19 +736,128 @@ func followersHandler(w http.ResponseWriter, r *http.Request, s *Session) {
 	q := r.Form.Get("q")
 
 	// ユーザ一覧を取得
-	followers, err := findFollowersByQuery(uid, q, UserSearchPageLimit, 0)
+	users, err := app.Followers.Search(uid, q, app.Config.UserSearchPageLimit, 0)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Sorry.", http.StatusInternalServerError)
 		return
 	}
-	fft := &FollowerForTemplate{Followers: followers}
+	ust := &UserSearchForTemplate{Query: q, Users: users}
 
 	// ユーザ一覧を表示
-	err = responseTemplate.ExecuteTemplate(w, "userSearch.tmpl", fft)
+	err = responseTemplate.ExecuteTemplate(w, "userSearch.tmpl", ust)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+}
+
+// [/tokens]のハンドラ
+func (app *App) tokensHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// トークンによるトークン管理は許可しない
+	if isTokenSession(s) == true {
+		http.Error(w, "Forbidden.", http.StatusForbidden)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Sorry.", http.StatusInternalServerError)
 		return
 	}
+
+	ttt := &AccessTokensForTemplate{}
+	switch r.Method {
+	case "GET":
+	case "POST":
+		// Postパラメータを取得してトークンを作成
+		if err := r.ParseForm(); err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+			return
+		}
+		t := &AccessToken{
+			UserID: uid,
+			Name:   r.PostFormValue("name"),
+			Scope:  r.PostFormValue("scope"),
+		}
+		// 入力チェック
+		if err := t.Validate(); err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+			return
+		}
+		if len(t.Messages) > 0 {
+			ttt.Messages = t.Messages
+		} else {
+			// 発行
+			if err := t.Entry(app.Store); err != nil {
+				log.Println(err)
+				http.Error(w, "Sorry.", http.StatusInternalServerError)
+				return
+			}
+			// 平文のトークンはこの画面でのみ表示する
+			ttt.Created = t
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	// トークン一覧の取得
+	tokens, err := app.findAccessTokensByUserID(uid)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	ttt.Tokens = tokens
+
+	err = responseTemplate.ExecuteTemplate(w, "tokens.tmpl", ttt)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+	}
+}
+
+// [/tokens/revoke]のハンドラ
+func (app *App) revokeTokenHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は存在しない
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	// トークンによるトークン管理は許可しない
+	if isTokenSession(s) == true {
+		http.Error(w, "Forbidden.", http.StatusForbidden)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// 失効するトークンを取得
+	tokenID, err := strconv.ParseInt(r.FormValue("token_id"), 10, 64)
+	if err != nil {
+		log.Println("token_id convert error")
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// トークンを失効
+	t := &AccessToken{ID: tokenID, UserID: uid}
+	if err := t.Remove(app.Store); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// トークン一覧へ回す
+	http.Redirect(w, r, "/tokens", http.StatusFound)
 }
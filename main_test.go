@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// 停止を始めた後のWebSocketは数えずに断る
+func TestWaitWebSocketsRejectsNewConnections(t *testing.T) {
+	app := &App{}
+	if app.addWebSocket() == false {
+		t.Fatal("addWebSocket before shutdown = false")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	waited := make(chan struct{})
+	go func() {
+		app.waitWebSockets(ctx)
+		close(waited)
+	}()
+
+	// 停止を始めるまで待つ
+	for closed := false; closed == false; time.Sleep(time.Millisecond) {
+		app.webSocketsMu.Lock()
+		closed = app.webSocketsClosed
+		app.webSocketsMu.Unlock()
+	}
+	if app.addWebSocket() == true {
+		t.Error("addWebSocket during shutdown = true")
+	}
+	// 接続中のものが終わるまで待つ
+	select {
+	case <-waited:
+		t.Fatal("waitWebSockets returned before Done")
+	default:
+	}
+	app.webSockets.Done()
+	select {
+	case <-waited:
+	case <-ctx.Done():
+		t.Fatal("waitWebSockets did not return after Done")
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// mentionPattern は@nameの形式のメンション
+// メールアドレスなどを除くため, 直前が英数字の場合はメンションとしない
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_]+)`)
+
+// MentionsForTemplate はメンション一覧画面用のデータ構造
+type MentionsForTemplate struct {
+	Sweets []Post
+	Older  string // より古いページのカーソル. なければ空
+	Newer  string // より新しいページのカーソル. なければ空
+}
+
+// parseMentions はmessage中でメンションされたユーザ名を出現順に重複なく返す
+func parseMentions(message string) []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(message, -1) {
+		if seen[m[1]] == false {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// entryMentions はpostIDのメッセージmessage中のメンションをpost_mentionsへ登録する
+// 同じ名前のユーザが複数いる場合は最も古いユーザへのメンションとし, 存在しない名前は無視する
+// 登録したメンションのユーザ名とユーザIDの対応を返す
+func entryMentions(tx *Tx, postID int64, message string) (map[string]int64, error) {
+	mentions := make(map[string]int64)
+	registered := make(map[int64]bool)
+	for _, name := range parseMentions(message) {
+		// ユーザ名からIDを解決
+		var userID int64
+		err := tx.QueryRow(`
+		SELECT
+			u.id
+		FROM
+			users u
+		WHERE
+			u.name = ?
+		ORDER BY
+			u.id
+		LIMIT 1
+		`, name).Scan(&userID)
+		switch {
+		case err == sql.ErrNoRows:
+			continue
+		case err != nil:
+			return nil, err
+		}
+		// 大文字小文字違いなどで同じユーザを指している場合は最初のものだけ登録
+		if registered[userID] == true {
+			continue
+		}
+		registered[userID] = true
+
+		// 登録
+		_, err = tx.Exec("INSERT INTO post_mentions(post_id, user_id, name) VALUES(?, ?, ?)", postID, userID, name)
+		if err != nil {
+			return nil, err
+		}
+		mentions[name] = userID
+	}
+	return mentions, nil
+}
+
+// postMentionsColumn はpost_mentionsを"user_id:name"の空白区切りでまとめるSELECT句の列
+// idにはposts pまたはo
+func postMentionsColumn(d Dialect, id string) string {
+	return `(SELECT ` + d.GroupConcat(d.Concat("m.user_id", "':'", "m.name"), " ") + ` FROM post_mentions m WHERE m.post_id = ` + id + `)`
+}
+
+// postMentionsColumnの値をユーザ名とユーザIDの対応にする
+// メンションがなければnilを返す
+func decodeMentions(str sql.NullString) map[string]int64 {
+	if str.Valid == false || str.String == "" {
+		return nil
+	}
+	mentions := make(map[string]int64)
+	for _, field := range strings.Fields(str.String) {
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		id, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		mentions[parts[1]] = id
+	}
+	return mentions
+}
+
+// メンション一覧を取得するSQLと引数を組み立てる
+// メンションされたユーザが閲覧するので, そのユーザに表示出来るものに絞る
+// condはposts pに対する条件, orderはASCまたはDESC
+func mentionedSweetsQuery(d Dialect, userID int64, cond *sqlConds, order string, limit int) (string, []interface{}) {
+	cond.visibleTo(userID)
+	query := fmt.Sprintf(`
+		SELECT`+postSelectColumns(d)+`
+		FROM`+postFromTables+`
+		INNER JOIN
+			post_mentions pm
+		ON
+			pm.post_id = p.id
+		WHERE
+			pm.user_id = ?
+		AND%[1]s
+		ORDER BY
+			p.created_at %[2]s, p.id %[2]s
+		LIMIT ?
+	`, cond, order)
+
+	// パラメータ組み立て
+	var args []interface{}
+	args = append(args, userID)
+	args = append(args, cond.args...)
+	args = append(args, limit)
+	return query, args
+}
+
+// MentionsPage はuserIDがメンションされたSweetの1ページ分を取得する
+// フォローしていないユーザからのメンションも含む. 引数と戻り値はTimelinePageと同じ
+func (st *Store) MentionsPage(userID int64, limit int, before *TimelineCursor, after *TimelineCursor) ([]Post, *TimelineCursor, *TimelineCursor, error) {
+	return pageSweets(limit, before, after, (*Post).Cursor,
+		func(limit int, before *TimelineCursor) ([]Post, error) {
+			cond := (&sqlConds{}).olderThan("p.created_at", "p.id", before)
+			return st.queryPosts(mentionedSweetsQuery(st.db.dialect, userID, cond, "DESC", limit))
+		},
+		func(limit int, after *TimelineCursor) ([]Post, error) {
+			cond := (&sqlConds{}).newerThan("p.created_at", "p.id", after)
+			posts, err := st.queryPosts(mentionedSweetsQuery(st.db.dialect, userID, cond, "ASC", limit))
+			if err != nil {
+				return nil, err
+			}
+			reverseSlice(posts)
+			return posts, nil
+		})
+}
+
+// [/mentions]のハンドラ
+func (app *App) mentionsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は存在しない
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.FormValue("before"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+	after, err := parseTimelineCursor(r.FormValue("after"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+
+	// メンションされたsweetsの取得
+	posts, older, newer, err := app.MentionsPage(uid, app.Config.TimelinePageLimit, before, after)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if err := app.fillLikedByMe(uid, posts); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	mft := &MentionsForTemplate{Sweets: posts}
+	if older != nil {
+		mft.Older = older.String()
+	}
+	if newer != nil {
+		mft.Newer = newer.String()
+	}
+
+	err = responseTemplate.ExecuteTemplate(w, "mentions.tmpl", mft)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		message string
+		want    []string
+	}{
+		{"@alice こんにちは", []string{"alice"}},
+		{"hi @alice and @bob", []string{"alice", "bob"}},
+		{"@山田_太郎 さん", []string{"山田_太郎"}},
+		{"(@alice)", []string{"alice"}},
+		{"「@alice」", []string{"alice"}},
+		// 重複は最初のもののみ
+		{"@alice @bob @alice", []string{"alice", "bob"}},
+		// 大文字小文字は区別したまま返す
+		{"@Alice @alice", []string{"Alice", "alice"}},
+		// メールアドレスはメンションとしない
+		{"mail to alice@example.com", []string{}},
+		// 直前が文字や数字, @の場合はメンションとしない
+		{"日本語@alice", []string{}},
+		{"1@alice", []string{}},
+		{"@@alice", []string{}},
+		{"@alice@bob", []string{"alice"}},
+		// 名前のない@
+		{"@ alice", []string{}},
+		{"", []string{}},
+	}
+	for _, tt := range tests {
+		if got := parseMentions(tt.message); reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("parseMentions(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+)
+
+// メッセージ中のリンクにする範囲
+type messageLink struct {
+	start int
+	end   int
+	href  string
+}
+
+// renderMessage はmessageをエスケープし, メンションとハッシュタグをリンクにしたHTMLを返す
+// メンションはユーザページへ, ハッシュタグはタグのsweet一覧へリンクする
+// mentionsにないユーザ名はリンクにしない
+func renderMessage(message string, mentions map[string]int64) template.HTML {
+	links := make([]messageLink, 0)
+	for _, m := range mentionPattern.FindAllStringSubmatchIndex(message, -1) {
+		// m[2], m[3]はユーザ名の範囲. 直前の@も含める
+		id, ok := mentions[message[m[2]:m[3]]]
+		if ok == false {
+			continue
+		}
+		links = append(links, messageLink{start: m[2] - 1, end: m[3], href: fmt.Sprintf("/users/%d", id)})
+	}
+	for _, m := range hashtagPattern.FindAllStringSubmatchIndex(message, -1) {
+		// m[2], m[3]は#の範囲, m[4], m[5]はタグ名の範囲
+		name := message[m[4]:m[5]]
+		if hashtagDigitsPattern.MatchString(name) == true {
+			continue
+		}
+		links = append(links, messageLink{start: m[2], end: m[5], href: tagPath(normalizeTag(name))})
+	}
+	// メンションとハッシュタグは重ならないので出現順に並べる
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].start < links[j].start
+	})
+
+	var b strings.Builder
+	pos := 0
+	for _, l := range links {
+		b.WriteString(template.HTMLEscapeString(message[pos:l.start]))
+		fmt.Fprintf(&b, `<a href="%s">%s</a>`, template.HTMLEscapeString(l.href), template.HTMLEscapeString(message[l.start:l.end]))
+		pos = l.end
+	}
+	b.WriteString(template.HTMLEscapeString(message[pos:]))
+	return template.HTML(b.String())
+}
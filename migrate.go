@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// バイナリへ埋め込んだDBごとのマイグレーション
+// db/migrations/{DialectのName}/以下にgooseの形式で置く
+//
+//go:embed db/migrations
+var migrationFiles embed.FS
+
+// MigrationTableName は適用したマイグレーションを記録するテーブル
+// gooseと同じテーブルを使うので, gooseで適用済のDBもそのまま扱える
+const MigrationTableName = "goose_db_version"
+
+// Migration は1つのマイグレーションファイル
+type Migration struct {
+	Version int64    // ファイル名の先頭の数字
+	Name    string   // ファイル名
+	Up      []string // 適用するSQL文
+	Down    []string // 取り消すSQL文
+}
+
+// MigrationStatus はマイグレーションの適用状況
+type MigrationStatus struct {
+	Migration
+	Applied   bool      // 適用済ならtrue
+	AppliedAt time.Time // 適用日時
+}
+
+// dialectのマイグレーションを読み込んでバージョン順に返す
+func loadMigrations(d Dialect) ([]Migration, error) {
+	dir := path.Join("db/migrations", d.Name())
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]Migration, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() == true || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		f, err := migrationFiles.Open(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, err := parseMigration(e.Name(), f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// gooseの形式のマイグレーションファイルを読み込む
+// -- +goose Up と -- +goose Down で区切り, 行末の;で文を区切る
+// -- +goose StatementBegin から StatementEnd までは;があっても1つの文とする
+func parseMigration(name string, r io.Reader) (Migration, error) {
+	m := Migration{Name: name}
+	version, err := strconv.ParseInt(strings.SplitN(name, "_", 2)[0], 10, 64)
+	if err != nil {
+		return m, fmt.Errorf("%s: invalid version", name)
+	}
+	m.Version = version
+
+	var section *[]string
+	var buf []string
+	inStatement := false
+	flush := func() {
+		if stmt := strings.TrimSpace(strings.Join(buf, "\n")); stmt != "" {
+			*section = append(*section, stmt)
+		}
+		buf = nil
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "-- +goose Up"):
+			section = &m.Up
+			continue
+		case strings.HasPrefix(trimmed, "-- +goose Down"):
+			if section == nil || len(buf) > 0 {
+				return m, fmt.Errorf("%s: unexpected +goose Down", name)
+			}
+			section = &m.Down
+			continue
+		case strings.HasPrefix(trimmed, "-- +goose StatementBegin"):
+			inStatement = true
+			continue
+		case strings.HasPrefix(trimmed, "-- +goose StatementEnd"):
+			inStatement = false
+			if section != nil {
+				flush()
+			}
+			continue
+		case strings.HasPrefix(trimmed, "--") || (trimmed == "" && len(buf) == 0):
+			continue
+		}
+		if section == nil {
+			return m, fmt.Errorf("%s: statement before +goose Up", name)
+		}
+		buf = append(buf, line)
+		if inStatement == false && strings.HasSuffix(trimmed, ";") {
+			flush()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return m, err
+	}
+	if section == nil {
+		return m, fmt.Errorf("%s: +goose Up not found", name)
+	}
+	if len(buf) > 0 {
+		return m, fmt.Errorf("%s: unterminated statement", name)
+	}
+	return m, nil
+}
+
+// 適用履歴のテーブルがなければ作る
+// gooseと同じくバージョン0を適用済として登録しておく
+func (db *DB) ensureMigrationTable() error {
+	var count int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + MigrationTableName).Scan(&count); err == nil {
+		return nil
+	}
+	if _, err := db.Exec(db.dialect.MigrationTable()); err != nil {
+		return err
+	}
+	_, err := db.Exec("INSERT INTO "+MigrationTableName+"(version_id, is_applied, tstamp) VALUES(?, ?, ?)", 0, true, time.Now())
+	return err
+}
+
+// 適用済のバージョンと適用日時を返す
+// 古いgooseは取り消しをis_appliedがfalseの行で記録するので, 記録順にたどる
+func (db *DB) appliedMigrations() (map[int64]time.Time, error) {
+	if err := db.ensureMigrationTable(); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT version_id, is_applied, tstamp FROM " + MigrationTableName + " ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var isApplied bool
+		var tstamp sql.NullTime
+		if err := rows.Scan(&version, &isApplied, &tstamp); err != nil {
+			return nil, err
+		}
+		if isApplied == true {
+			applied[version] = tstamp.Time
+		} else {
+			delete(applied, version)
+		}
+	}
+	return applied, rows.Err()
+}
+
+// mのupまたはdownのSQL文をトランザクション内で実行して適用履歴を更新する
+func (db *DB) runMigration(m *Migration, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := m.Down
+	if up == true {
+		statements = m.Up
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("%s: %v", m.Name, err)
+		}
+	}
+	if up == true {
+		_, err = tx.Exec("INSERT INTO "+MigrationTableName+"(version_id, is_applied, tstamp) VALUES(?, ?, ?)", m.Version, true, time.Now())
+	} else {
+		_, err = tx.Exec("DELETE FROM "+MigrationTableName+" WHERE version_id = ?", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateUp は未適用のマイグレーションを古い順に全て適用し, 適用したものを返す
+func MigrateUp(db *DB) ([]Migration, error) {
+	migrations, err := loadMigrations(db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	done := make([]Migration, 0)
+	for i := range migrations {
+		if _, ok := applied[migrations[i].Version]; ok == true {
+			continue
+		}
+		if err := db.runMigration(&migrations[i], true); err != nil {
+			return done, err
+		}
+		done = append(done, migrations[i])
+	}
+	return done, nil
+}
+
+// MigrateDown は最後に適用したマイグレーションを1つ取り消し, 取り消したものを返す
+// 取り消せるものがなければnilを返す
+func MigrateDown(db *DB) (*Migration, error) {
+	migrations, err := loadMigrations(db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[migrations[i].Version]; ok == false {
+			continue
+		}
+		if err := db.runMigration(&migrations[i], false); err != nil {
+			return nil, err
+		}
+		return &migrations[i], nil
+	}
+	return nil, nil
+}
+
+// MigrationStatuses は全てのマイグレーションの適用状況をバージョン順に返す
+func MigrationStatuses(db *DB) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		at, ok := applied[m.Version]
+		statuses = append(statuses, MigrationStatus{Migration: m, Applied: ok, AppliedAt: at})
+	}
+	return statuses, nil
+}
+
+// migrateサブコマンドの処理
+// argsはup, down, statusのいずれか1つ
+func runMigrate(config *Config, args []string, w io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: migrate up|down|status")
+	}
+	db, err := openDB(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		done, err := MigrateUp(db)
+		for _, m := range done {
+			fmt.Fprintln(w, "OK   ", m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Fprintln(w, "no migrations to run")
+		}
+	case "down":
+		m, err := MigrateDown(db)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			fmt.Fprintln(w, "no migrations to roll back")
+			return nil
+		}
+		fmt.Fprintln(w, "OK   ", m.Name)
+	case "status":
+		statuses, err := MigrationStatuses(db)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "    Applied At                  Migration")
+		fmt.Fprintln(w, "    =======================================")
+		for _, s := range statuses {
+			at := "Pending"
+			if s.Applied == true {
+				at = s.AppliedAt.Format(time.ANSIC)
+			}
+			fmt.Fprintf(w, "    %-24s -- %s\n", at, s.Name)
+		}
+	default:
+		return errors.New("usage: migrate up|down|status")
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseMigration(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		up   []string
+		down []string
+		err  bool
+	}{
+		{
+			name: "1_simple.sql",
+			src: `-- +goose Up
+-- コメントは読み飛ばす
+CREATE TABLE a (id INT);
+
+CREATE TABLE b (
+  id INT
+);
+
+-- +goose Down
+DROP TABLE b;
+DROP TABLE a;
+`,
+			up:   []string{"CREATE TABLE a (id INT);", "CREATE TABLE b (\n  id INT\n);"},
+			down: []string{"DROP TABLE b;", "DROP TABLE a;"},
+		},
+		{
+			// StatementBeginからStatementEndまでは;があっても1つの文
+			name: "2_statement.sql",
+			src: `-- +goose Up
+-- +goose StatementBegin
+CREATE TRIGGER t AFTER INSERT ON a
+BEGIN
+  UPDATE a SET id = 1;
+  UPDATE a SET id = 2;
+END;
+-- +goose StatementEnd
+CREATE TABLE b (id INT);
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER t;
+-- +goose StatementEnd
+`,
+			up: []string{
+				"CREATE TRIGGER t AFTER INSERT ON a\nBEGIN\n  UPDATE a SET id = 1;\n  UPDATE a SET id = 2;\nEND;",
+				"CREATE TABLE b (id INT);",
+			},
+			down: []string{"DROP TRIGGER t;"},
+		},
+		{name: "3_up_only.sql", src: "-- +goose Up\nCREATE TABLE a (id INT);\n", up: []string{"CREATE TABLE a (id INT);"}},
+		{name: "x_version.sql", src: "-- +goose Up\nCREATE TABLE a (id INT);\n", err: true},
+		{name: "4_no_up.sql", src: "CREATE TABLE a (id INT);\n", err: true},
+		{name: "5_empty.sql", src: "", err: true},
+		{name: "6_down_first.sql", src: "-- +goose Down\nDROP TABLE a;\n", err: true},
+		{name: "7_unterminated.sql", src: "-- +goose Up\nCREATE TABLE a (id INT)\n", err: true},
+		{name: "8_down_inside.sql", src: "-- +goose Up\nCREATE TABLE a (\n-- +goose Down\n);\n", err: true},
+	}
+	for _, tt := range tests {
+		m, err := parseMigration(tt.name, strings.NewReader(tt.src))
+		if tt.err == true {
+			if err == nil {
+				t.Errorf("parseMigration(%s) succeeded", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMigration(%s): %v", tt.name, err)
+			continue
+		}
+		if reflect.DeepEqual(m.Up, tt.up) == false {
+			t.Errorf("parseMigration(%s).Up = %q, want %q", tt.name, m.Up, tt.up)
+		}
+		if reflect.DeepEqual(m.Down, tt.down) == false {
+			t.Errorf("parseMigration(%s).Down = %q, want %q", tt.name, m.Down, tt.down)
+		}
+	}
+}
+
+// 全てのDBのマイグレーションを読み込める
+func TestLoadMigrations(t *testing.T) {
+	for _, d := range []Dialect{MySQLDialect{}, SQLiteDialect{}, PostgresDialect{}} {
+		migrations, err := loadMigrations(d)
+		if err != nil {
+			t.Errorf("loadMigrations(%s): %v", d.Name(), err)
+			continue
+		}
+		for _, m := range migrations {
+			if len(m.Up) == 0 || len(m.Down) == 0 {
+				t.Errorf("%s/%s has %d up and %d down statements", d.Name(), m.Name, len(m.Up), len(m.Down))
+			}
+		}
+	}
+}
+
+// SQLiteのDBでup, down, upの順に適用と取り消しが出来る
+func TestMigrateRoundTrip(t *testing.T) {
+	config := defaultConfig()
+	config.DBDriver = "sqlite3"
+	config.DBName = filepath.Join(t.TempDir(), "test.db")
+	db, err := openDB(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	migrations, err := loadMigrations(db.dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := migrations[len(migrations)-1]
+
+	tables := func() []string {
+		t.Helper()
+		rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, name)
+		}
+		return names
+	}
+	pending := func() []string {
+		t.Helper()
+		statuses, err := MigrationStatuses(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, s := range statuses {
+			if s.Applied == false {
+				names = append(names, s.Name)
+			}
+		}
+		return names
+	}
+
+	done, err := MigrateUp(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(migrations) {
+		t.Errorf("MigrateUp applied %d, want %d", len(done), len(migrations))
+	}
+	if p := pending(); len(p) != 0 {
+		t.Errorf("pending after up = %q", p)
+	}
+	created := tables()
+
+	// 最後のマイグレーションだけを取り消す
+	m, err := MigrateDown(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Version != last.Version {
+		t.Fatalf("MigrateDown = %+v, want %s", m, last.Name)
+	}
+	if p := pending(); reflect.DeepEqual(p, []string{last.Name}) == false {
+		t.Errorf("pending after down = %q, want %q", p, last.Name)
+	}
+	if len(migrations) == 1 {
+		if got := tables(); reflect.DeepEqual(got, []string{MigrationTableName}) == false {
+			t.Errorf("tables after down = %q", got)
+		}
+	}
+
+	// 取り消したものだけを適用し直す
+	done, err = MigrateUp(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 1 || done[0].Version != last.Version {
+		t.Errorf("MigrateUp after down applied %+v, want %s", done, last.Name)
+	}
+	if got := tables(); reflect.DeepEqual(got, created) == false {
+		t.Errorf("tables after up, down, up = %q, want %q", got, created)
+	}
+	if done, err := MigrateUp(db); err != nil || len(done) != 0 {
+		t.Errorf("MigrateUp when up to date = %+v, %v", done, err)
+	}
+}
+
+func TestRunMigrate(t *testing.T) {
+	config := defaultConfig()
+	config.DBDriver = "sqlite3"
+	config.DBName = filepath.Join(t.TempDir(), "test.db")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"status"}, "Pending"},
+		{[]string{"up"}, "OK"},
+		{[]string{"up"}, "no migrations to run"},
+		{[]string{"down"}, "OK"},
+		{[]string{"status"}, "Pending"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := runMigrate(config, tt.args, &out); err != nil {
+			t.Fatalf("migrate %q: %v", tt.args, err)
+		}
+		if strings.Contains(out.String(), tt.want) == false {
+			t.Errorf("migrate %q = %q, want %q", tt.args, out.String(), tt.want)
+		}
+	}
+	for _, args := range [][]string{nil, {"sideways"}, {"up", "down"}} {
+		if err := runMigrate(config, args, &bytes.Buffer{}); err == nil {
+			t.Errorf("migrate %q succeeded", args)
+		}
+	}
+}
+
+// マイグレーションのUpの文から作られるテーブルと列の名前を返す
+// 列の型や制約, インデックスは比べないので読み飛ばす
+func migratedSchema(migrations []Migration) (map[string][]string, error) {
+	schema := make(map[string][]string)
+	// 列の定義ではない句の先頭の語
+	notColumn := map[string]bool{"PRIMARY": true, "UNIQUE": true, "INDEX": true, "KEY": true, "CONSTRAINT": true, "FOREIGN": true, "FULLTEXT": true, "CHECK": true}
+	removeColumn := func(table string, column string) {
+		columns := schema[table][:0]
+		for _, c := range schema[table] {
+			if c != column {
+				columns = append(columns, c)
+			}
+		}
+		schema[table] = columns
+	}
+	for _, m := range migrations {
+		for _, stmt := range m.Up {
+			words := strings.Fields(strings.Trim(stmt, ";"))
+			if len(words) < 3 {
+				continue
+			}
+			table := strings.Trim(words[2], "`\"(")
+			switch {
+			case strings.EqualFold(words[0], "CREATE") && strings.EqualFold(words[1], "TABLE"):
+				// 括弧の外の,で列の定義を区切る
+				body := stmt[strings.Index(stmt, "(")+1 : strings.LastIndex(stmt, ")")]
+				depth, start := 0, 0
+				var defs []string
+				for i, r := range body + "," {
+					switch {
+					case r == '(':
+						depth++
+					case r == ')':
+						depth--
+					case r == ',' && depth == 0:
+						defs = append(defs, body[start:i])
+						start = i + 1
+					}
+				}
+				schema[table] = nil
+				for _, def := range defs {
+					if f := strings.Fields(def); len(f) > 0 && notColumn[strings.ToUpper(f[0])] == false {
+						schema[table] = append(schema[table], strings.Trim(f[0], "`\""))
+					}
+				}
+			case strings.EqualFold(words[0], "DROP") && strings.EqualFold(words[1], "TABLE"):
+				delete(schema, table)
+			case strings.EqualFold(words[0], "ALTER") && strings.EqualFold(words[1], "TABLE") && len(words) >= 5:
+				args := words[4:]
+				if strings.EqualFold(args[0], "COLUMN") {
+					args = args[1:]
+				}
+				switch strings.ToUpper(words[3]) {
+				case "ADD":
+					if notColumn[strings.ToUpper(args[0])] == false {
+						schema[table] = append(schema[table], args[0])
+					}
+				case "DROP":
+					removeColumn(table, args[0])
+				case "CHANGE":
+					removeColumn(table, args[0])
+					schema[table] = append(schema[table], args[1])
+				case "RENAME":
+					if len(args) >= 3 && strings.EqualFold(args[1], "TO") {
+						removeColumn(table, args[0])
+						schema[table] = append(schema[table], args[2])
+					}
+				case "MODIFY", "ALTER":
+				default:
+					return nil, fmt.Errorf("%s: unknown statement: %s", m.Name, stmt)
+				}
+			}
+		}
+	}
+	for _, columns := range schema {
+		sort.Strings(columns)
+	}
+	return schema, nil
+}
+
+// DBごとのマイグレーションが同じバージョンまで進み, 同じテーブルと列を作る
+// sqlite3とpostgresは初めのマイグレーションを1つにまとめてあるので, mysqlへ追加したら同じバージョンで各DBへも追加する
+func TestMigrationsMatchAcrossDialects(t *testing.T) {
+	schemas := make(map[string]map[string][]string)
+	var mysqlLast int64
+	for _, d := range []Dialect{MySQLDialect{}, SQLiteDialect{}, PostgresDialect{}} {
+		migrations, err := loadMigrations(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := migrations[len(migrations)-1].Version
+		if _, ok := d.(MySQLDialect); ok == true {
+			mysqlLast = last
+		} else if last != mysqlLast {
+			t.Errorf("latest %s migration is %d, want %d as mysql", d.Name(), last, mysqlLast)
+		}
+		schemas[d.Name()], err = migratedSchema(migrations)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"sqlite3", "postgres"} {
+		if reflect.DeepEqual(schemas[name], schemas["mysql"]) == false {
+			t.Errorf("%s schema = %v\nwant %v as mysql", name, schemas[name], schemas["mysql"])
+		}
+	}
+
+	// 読み取った列が実際にSQLiteへ作られる列と一致する
+	st := newTestStore(t)
+	for table, want := range schemas["sqlite3"] {
+		rows, err := st.db.Query("SELECT name FROM pragma_table_info(?)", table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, name)
+		}
+		rows.Close()
+		sort.Strings(got)
+		if reflect.DeepEqual(got, want) == false {
+			t.Errorf("sqlite3 %s columns = %q, want %q", table, got, want)
+		}
+	}
+}
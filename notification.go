@@ -0,0 +1,311 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+)
+
+// NotificationPageLimit は通知一覧の1ページの件数
+const NotificationPageLimit = 20
+
+// 通知の種類
+const (
+	// NotificationFollow はフォローされた通知
+	NotificationFollow = "follow"
+	// NotificationFollowRequest は鍵アカウントへフォローリクエストされた通知
+	NotificationFollowRequest = "follow_request"
+	// NotificationMention はsweetでメンションされた通知
+	NotificationMention = "mention"
+	// NotificationReply はsweetへ返信された通知
+	NotificationReply = "reply"
+	// NotificationLike はsweetをいいねされた通知
+	NotificationLike = "like"
+)
+
+// Notification はユーザへの通知
+type Notification struct {
+	ID        int64
+	UserID    int64     // 通知を受け取るユーザのID
+	ActorID   int64     // フォローやいいねなどをしたユーザのID
+	ActorName string    // ActorIDのユーザ名
+	Type      string    // 通知の種類. NotificationFollowなど
+	PostID    int64     // 対象のsweetのID. なければ0
+	Message   string    // 対象のsweetのメッセージ
+	CreatedAt time.Time // 通知した日時
+	Read      bool      // 既読であればtrue
+}
+
+// NotificationsForTemplate は通知一覧画面用のデータ構造
+type NotificationsForTemplate struct {
+	Notifications []Notification
+	Older         string // より古いページのカーソル. なければ空
+}
+
+// Text は通知の内容を表す文を返す
+func (n *Notification) Text() string {
+	switch n.Type {
+	case NotificationFollow:
+		return "あなたをフォローしました"
+	case NotificationFollowRequest:
+		return "あなたにフォローリクエストを送りました"
+	case NotificationMention:
+		return "あなたをメンションしました"
+	case NotificationReply:
+		return "あなたのすいーとに返信しました"
+	case NotificationLike:
+		return "あなたのすいーとをいいねしました"
+	default:
+		return ""
+	}
+}
+
+// Cursor は通知一覧でのnの位置を表すカーソルを返す
+func (n *Notification) Cursor() *TimelineCursor {
+	return &TimelineCursor{CreatedAt: n.CreatedAt, ID: n.ID}
+}
+
+// entryNotification はtx中でuserIDへactorIDからの通知を登録する
+// postIDがなければ0を渡す. 自分自身の操作は通知しない
+// 登録した場合はコミット後にHubへ配信するイベントを返す
+func entryNotification(tx *Tx, userID int64, actorID int64, notificationType string, postID int64) (*HubEvent, error) {
+	if userID == actorID {
+		return nil, nil
+	}
+	var post sql.NullInt64
+	if postID != 0 {
+		post = sql.NullInt64{Int64: postID, Valid: true}
+	}
+	id, err := tx.Insert("INSERT INTO notifications(user_id, actor_user_id, type, post_id, created_at) VALUES(?, ?, ?, ?, ?)", userID, actorID, notificationType, post, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &HubEvent{Type: HubEventNotification, UserID: actorID, TargetUserID: userID, PostID: postID, NotificationID: id}, nil
+}
+
+// notificationVisibleCond は通知一覧に表示する通知の条件
+// 削除済のsweetの通知と, ミュート, ブロック関係にあるユーザからの通知を除く
+// 鍵アカウントからのメンション, 返信は承認したフォロワーでなければ除く
+const notificationVisibleCond = `
+			(n.post_id IS NULL OR p.deleted_at IS NULL)
+		AND
+			NOT EXISTS (
+				SELECT
+					1
+				FROM
+					mutes m
+				WHERE
+					m.user_id = n.user_id
+				AND
+					m.muted_user_id = n.actor_user_id
+			)
+		AND
+			NOT EXISTS (
+				SELECT
+					1
+				FROM
+					blocks b
+				WHERE
+					(b.user_id = n.user_id AND b.blocked_user_id = n.actor_user_id)
+				OR
+					(b.user_id = n.actor_user_id AND b.blocked_user_id = n.user_id)
+			)
+		AND
+			(n.type NOT IN ('mention', 'reply')
+			OR a.protected = FALSE
+			OR EXISTS (SELECT 1 FROM followers f WHERE f.follower_user_id = n.user_id AND f.followee_user_id = n.actor_user_id))`
+
+// notificationFromTables は通知を取得する際のFROM句
+// 操作したユーザと対象のsweetを結合する
+const notificationFromTables = `
+			notifications n
+		INNER JOIN
+			users a
+		ON
+			a.id = n.actor_user_id
+		LEFT JOIN
+			posts p
+		ON
+			p.id = n.post_id`
+
+// NotificationsPage はuserIDへの通知の1ページ分を新しい順に取得する
+// beforeを指定した場合はそれより古いものだけを取得する
+// 古い側のページがあればそのページを指すカーソルを返す
+func (st *Store) NotificationsPage(userID int64, limit int, before *TimelineCursor) (notifications []Notification, older *TimelineCursor, err error) {
+	older, _, err = pageKeyset(limit, before, nil,
+		func(limit int, before *TimelineCursor) (int, error) {
+			cond := (&sqlConds{}).olderThan("n.created_at", "n.id", before)
+			notifications, err = st.queryNotifications(userID, cond, limit)
+			return len(notifications), err
+		},
+		nil,
+		func(i int, j int) { notifications = notifications[i:j] },
+		func(i int) *TimelineCursor { return notifications[i].Cursor() })
+	if err != nil {
+		return nil, nil, err
+	}
+	return notifications, older, nil
+}
+
+// findNotification はuserIDへの通知のうちidのものを取得する
+// 表示しない通知であればfalseを返す
+func (st *Store) findNotification(userID int64, id int64) (Notification, bool, error) {
+	notifications, err := st.queryNotifications(userID, (&sqlConds{}).add(`
+			n.id = ?`, id), 1)
+	if err != nil || len(notifications) == 0 {
+		return Notification{}, false, err
+	}
+	return notifications[0], true, nil
+}
+
+// userIDへの通知をcondで絞り込んで新しい順に最大limit件取得する
+// condはnotifications nに対する条件
+func (st *Store) queryNotifications(userID int64, cond *sqlConds, limit int) ([]Notification, error) {
+	db := st.db
+	// パラメータ組み立て
+	var args []interface{}
+	args = append(args, userID)
+	args = append(args, cond.args...)
+	args = append(args, limit)
+
+	// SQL発行
+	rows, err := db.Query(`
+		SELECT
+			n.id,
+			n.user_id,
+			n.actor_user_id,
+			a.name,
+			n.type,
+			n.post_id,
+			p.message,
+			n.created_at,
+			n.read_at IS NOT NULL
+		FROM`+notificationFromTables+`
+		WHERE
+			n.user_id = ?
+		AND`+cond.String()+`
+		AND`+notificationVisibleCond+`
+		ORDER BY
+			n.created_at DESC, n.id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := make([]Notification, 0)
+	for rows.Next() {
+		var n Notification
+		var postID sql.NullInt64
+		var message sql.NullString
+		if err := rows.Scan(&n.ID, &n.UserID, &n.ActorID, &n.ActorName, &n.Type, &postID, &message, &n.CreatedAt, &n.Read); err != nil {
+			return nil, err
+		}
+		n.PostID = postID.Int64
+		n.Message = message.String
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// countUnreadNotifications はuserIDへの未読の通知の数を返す
+func (st *Store) countUnreadNotifications(userID int64) (int64, error) {
+	db := st.db
+
+	// クエリ発行
+	var count int64
+	err := db.QueryRow(`
+	SELECT
+		COUNT(*)
+	FROM`+notificationFromTables+`
+	WHERE
+		n.user_id = ?
+	AND
+		n.read_at IS NULL
+	AND`+notificationVisibleCond, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// markNotificationsRead はuserIDへのmaxID以前の通知を既読にする
+// maxIDが0なら全ての通知を既読にする
+func (st *Store) markNotificationsRead(userID int64, maxID int64) error {
+	db := st.db
+
+	// プリペアードステートメント生成
+	stmt, err := db.Prepare(`
+		UPDATE
+			notifications
+		SET
+			read_at = ?
+		WHERE
+			user_id = ?
+		AND
+			(? = 0 OR id <= ?)
+		AND
+			read_at IS NULL
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// クエリ発行
+	_, err = stmt.Exec(time.Now(), userID, maxID, maxID)
+	return err
+}
+
+// [/notifications]のハンドラ
+// 表示した通知は既読にする
+func (app *App) notificationsHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は存在しない
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.FormValue("before"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+
+	// 通知の取得
+	notifications, older, err := app.NotificationsPage(uid, NotificationPageLimit, before)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	nft := &NotificationsForTemplate{Notifications: notifications}
+	if older != nil {
+		nft.Older = older.String()
+	}
+
+	// 既読にする. 表示は取得した時点の状態で行う
+	if len(notifications) > 0 {
+		if err := app.markNotificationsRead(uid, notifications[0].ID); err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = responseTemplate.ExecuteTemplate(w, "notifications.tmpl", nft)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// PasswordAlgorithmSHA256 は旧来のpassword + saltのSHA-256(検証専用)
+	PasswordAlgorithmSHA256 = "sha256"
+	// PasswordAlgorithmBcrypt はbcrypt
+	PasswordAlgorithmBcrypt = "bcrypt"
+	// PasswordAlgorithmScrypt はscrypt
+	PasswordAlgorithmScrypt = "scrypt"
+	// PasswordAlgorithmArgon2id はargon2id
+	PasswordAlgorithmArgon2id = "argon2id"
+
+	// DefaultPasswordAlgorithm は新規登録と再ハッシュ化に用いるアルゴリズム
+	DefaultPasswordAlgorithm = PasswordAlgorithmArgon2id
+)
+
+// PasswordHasher はパスワードのハッシュ化と検証を行う
+type PasswordHasher interface {
+	// Hash はpasswordとsaltからDBへ保存するハッシュ文字列を生成する
+	Hash(password string, salt string) (string, error)
+	// Verify はpasswordとsaltがhashedと一致すればtrueを返す
+	Verify(password string, salt string, hashed string) (bool, error)
+}
+
+// アルゴリズム識別子とPasswordHasherの対応
+var passwordHashers = map[string]PasswordHasher{
+	PasswordAlgorithmSHA256:   sha256Hasher{},
+	PasswordAlgorithmBcrypt:   bcryptHasher{cost: bcrypt.DefaultCost},
+	PasswordAlgorithmScrypt:   scryptHasher{n: 32768, r: 8, p: 1, keyLen: 32},
+	PasswordAlgorithmArgon2id: argon2idHasher{time: 1, memory: 64 * 1024, threads: 4, keyLen: 32},
+}
+
+// アルゴリズム識別子に対応するPasswordHasherを返す
+func passwordHasher(algorithm string) (PasswordHasher, error) {
+	h, ok := passwordHashers[algorithm]
+	if ok == false {
+		return nil, errors.New("unknown password algorithm: " + algorithm)
+	}
+	return h, nil
+}
+
+// 旧来のSHA-256(password + salt)
+// 既存データの検証のためだけに残している
+type sha256Hasher struct{}
+
+func (sha256Hasher) Hash(password string, salt string) (string, error) {
+	str := password + salt
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(str))), nil
+}
+
+func (h sha256Hasher) Verify(password string, salt string, hashed string) (bool, error) {
+	calc, _ := h.Hash(password, salt)
+	return subtle.ConstantTimeCompare([]byte(calc), []byte(hashed)) == 1, nil
+}
+
+// bcrypt
+// ソルトはハッシュ文字列に含まれるのでsaltは使わない
+type bcryptHasher struct {
+	cost int
+}
+
+func (h bcryptHasher) Hash(password string, salt string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (h bcryptHasher) Verify(password string, salt string, hashed string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
+	switch {
+	case err == bcrypt.ErrMismatchedHashAndPassword:
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+// scrypt
+// パラメータを変更しても既存データを検証できるよう"N,r,p$hash"の形式で保存する
+type scryptHasher struct {
+	n      int
+	r      int
+	p      int
+	keyLen int
+}
+
+func (h scryptHasher) Hash(password string, salt string) (string, error) {
+	key, err := scrypt.Key([]byte(password), []byte(salt), h.n, h.r, h.p, h.keyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d,%d,%d$%x", h.n, h.r, h.p, key), nil
+}
+
+func (scryptHasher) Verify(password string, salt string, hashed string) (bool, error) {
+	var stored scryptHasher
+	var hexKey string
+	if _, err := fmt.Sscanf(hashed, "%d,%d,%d$%s", &stored.n, &stored.r, &stored.p, &hexKey); err != nil {
+		return false, err
+	}
+	want, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return false, err
+	}
+	key, err := scrypt.Key([]byte(password), []byte(salt), stored.n, stored.r, stored.p, len(want))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, want) == 1, nil
+}
+
+// argon2id
+// パラメータを変更しても既存データを検証できるよう"t,m,p$hash"の形式で保存する
+type argon2idHasher struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	keyLen  uint32
+}
+
+func (h argon2idHasher) Hash(password string, salt string) (string, error) {
+	key := argon2.IDKey([]byte(password), []byte(salt), h.time, h.memory, h.threads, h.keyLen)
+	return fmt.Sprintf("%d,%d,%d$%x", h.time, h.memory, h.threads, key), nil
+}
+
+func (argon2idHasher) Verify(password string, salt string, hashed string) (bool, error) {
+	var stored argon2idHasher
+	var hexKey string
+	if _, err := fmt.Sscanf(hashed, "%d,%d,%d$%s", &stored.time, &stored.memory, &stored.threads, &hexKey); err != nil {
+		return false, err
+	}
+	want, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), []byte(salt), stored.time, stored.memory, stored.threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(key, want) == 1, nil
+}
@@ -1,31 +1,91 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 	"unicode/utf8"
 )
 
 // Post はメッセージ投稿のメッセージ1つを表す構造体
 type Post struct {
-	ID        int64
-	UserID    int64
-	UserName  string
-	Message   string
-	CreatedAt time.Time
+	ID         int64
+	UserID     int64
+	UserName   string
+	Message    string
+	InReplyTo  int64     // 返信先のPostのID. 返信でなければ0
+	ResweetOf  int64     // リスイート元のPostのID. Messageが空ならリスイート, あれば引用
+	ReplyCount int64     // 返信の数
+	LikeCount  int64     // いいねの数
+	LikedByMe  bool      // 閲覧者がいいねしていればtrue
+	LikedAt    time.Time // いいね一覧でのいいねした日時
+	CreatedAt  time.Time
+	EditedAt   time.Time        // 最後に編集した日時. 編集していなければゼロ値
+	Deleted    bool             // 削除済であればtrue
+	Mentions   map[string]int64 // メンションしたユーザ名とユーザIDの対応
+	Original   *Post            // リスイート元のPost. ResweetOfが0ならnil
+	Depth      int              // スレッド表示時の返信の深さ
+	Messages   []string         // エラーメッセージ
 }
 
+// SQLPostRepository はpostsテーブルへ保存するPostRepository
+type SQLPostRepository struct {
+	st *Store
+}
+
+// PostEditableDuration は投稿後にsweetを編集できる期間
+const PostEditableDuration = 30 * time.Minute
+
 // TimelineForTemplate はタイムライン画面用のデータ構造
 type TimelineForTemplate struct {
-	Messages []string
-	Sweets   []Post
+	Messages           []string
+	Sweets             []Post
+	Older              string // より古いページのカーソル. なければ空
+	Newer              string // より新しいページのカーソル. なければ空
+	TrendingTags       []Tag  // サイドバーに表示するトレンドのタグ
+	FollowRequestCount int64  // 承認待ちのフォローリクエストの数
+	UnreadCount        int64  // 未読の通知の数
+}
+
+// IsResweet はpがコメントなしのリスイートであればtrueを返す
+func (p *Post) IsResweet() bool {
+	return p.ResweetOf != 0 && p.Message == ""
+}
+
+// IsQuote はpがコメント付きの引用すいーとであればtrueを返す
+func (p *Post) IsQuote() bool {
+	return p.ResweetOf != 0 && p.Message != ""
+}
+
+// MessageHTML はメンションとハッシュタグをリンクにしたメッセージのHTMLを返す
+func (p *Post) MessageHTML() template.HTML {
+	return renderMessage(p.Message, p.Mentions)
+}
+
+// IsEdited はpが編集されていればtrueを返す
+func (p *Post) IsEdited() bool {
+	return p.EditedAt.IsZero() == false
+}
+
+// IsEditable は時刻tにpを編集できればtrueを返す
+// 削除済のsweetとリスイートは編集できない
+func (p *Post) IsEditable(t time.Time) bool {
+	return p.Deleted == false && p.IsResweet() == false && t.Sub(p.CreatedAt) <= PostEditableDuration
 }
 
 // Validate はDB登録前のバリデーションチェック
-func (p *Post) Validate() error {
+// ResweetOfを指定してMessageが空の場合はリスイートとして扱う
+func (p *Post) Validate(st *Store) error {
 	var messages []string
 
 	// UserIDの登録済みチェック
-	exist, err := isExistUserID(p.UserID)
+	exist, err := st.Users.Exists(p.UserID)
 	if err != nil {
 		return err
 	}
@@ -34,103 +94,1013 @@ func (p *Post) Validate() error {
 	}
 
 	// 投稿メッセージの文字数チェック
-	if n := utf8.RuneCountInString(p.Message); n < 1 || 140 < n {
+	// リスイートはメッセージなし
+	if n := utf8.RuneCountInString(p.Message); p.IsResweet() == false && (n < 1 || 140 < n) {
 		messages = append(messages, "投稿は1文字以上, 140字以内で行ってください")
 	}
+
+	// 返信先の存在チェック
+	if p.InReplyTo != 0 {
+		var parent Post
+		exist, err := st.Posts.FindByID(&parent, p.InReplyTo)
+		if err != nil {
+			return err
+		}
+		// 表示出来ないユーザのsweetは存在しないものとして扱う
+		visible := false
+		if exist == true {
+			visible, err = st.isVisibleUser(p.UserID, parent.UserID)
+			if err != nil {
+				return err
+			}
+		}
+		if exist == false || parent.Deleted == true || parent.IsResweet() == true || visible == false {
+			messages = append(messages, "返信先のすいーとは存在しません")
+		}
+		if p.IsResweet() == true {
+			messages = append(messages, "リスイートは返信に出来ません")
+		}
+	}
+
+	// リスイート元の存在チェック
+	if p.ResweetOf != 0 {
+		var original Post
+		exist, err := st.Posts.FindByID(&original, p.ResweetOf)
+		if err != nil {
+			return err
+		}
+		visible := false
+		if exist == true {
+			visible, err = st.isVisibleUser(p.UserID, original.UserID)
+			if err != nil {
+				return err
+			}
+		}
+		if exist == false || original.Deleted == true || original.IsResweet() == true || visible == false {
+			messages = append(messages, "リスイート元のすいーとは存在しません")
+		} else if protected, err := st.isProtectedUser(original.UserID); err != nil {
+			return err
+		} else if protected == true && original.UserID != p.UserID {
+			// 鍵アカウントのsweetはフォロワー以外に広めない
+			messages = append(messages, "鍵アカウントのすいーとはリスイート出来ません")
+		} else if p.IsResweet() == true {
+			// 自分のすいーとや同じすいーとを重ねてリスイートしない
+			if original.UserID == p.UserID {
+				messages = append(messages, "自分のすいーとはリスイート出来ません")
+			}
+			resweeted, err := st.Posts.IsResweeted(p.UserID, p.ResweetOf)
+			if err != nil {
+				return err
+			}
+			if resweeted == true {
+				messages = append(messages, "既にリスイートしています")
+			}
+		}
+	}
+
+	// エラーメッセージを登録しておく
+	p.Messages = messages
+
 	return nil
 }
 
 // Entry はDBへ投稿情報を新規登録するメソッド
-func (p *Post) Entry() error {
-	// コネクション取得
-	db, err := DBConnection()
-	if err != nil {
-		return err
-	}
+func (pr *SQLPostRepository) Entry(p *Post) error {
+	db := pr.st.db
 
-	// プリペアードステートメント生成
-	stmt, err := db.Prepare("INSERT INTO posts(user_id, message, created_at) VALUES(?, ?, ?)")
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
+	defer tx.Rollback()
 
+	// 返信やリスイートでなければNULLを登録
+	var inReplyTo, resweetOf sql.NullInt64
+	if p.InReplyTo != 0 {
+		inReplyTo = sql.NullInt64{Int64: p.InReplyTo, Valid: true}
+	}
+	if p.ResweetOf != 0 {
+		resweetOf = sql.NullInt64{Int64: p.ResweetOf, Valid: true}
+	}
 	// 投稿時刻登録
 	p.CreatedAt = time.Now()
 	// クエリ発行
-	result, err := stmt.Exec(p.UserID, p.Message, p.CreatedAt)
+	insertID, err := tx.Insert("INSERT INTO posts(user_id, in_reply_to, resweet_of, message, created_at) VALUES(?, ?, ?, ?, ?)", p.UserID, inReplyTo, resweetOf, p.Message, p.CreatedAt)
 	if err != nil {
 		return err
 	}
-	// 登録したIDを構造体へ入れてやる
-	insertID, err := result.LastInsertId()
+
+	// メンションを登録
+	mentions, err := entryMentions(tx, insertID, p.Message)
+	if err != nil {
+		return err
+	}
+	// ハッシュタグを登録
+	if err := entryTags(tx, insertID, p.Message, p.CreatedAt); err != nil {
+		return err
+	}
+	// 返信先とメンションしたユーザへ通知
+	events, err := entryPostNotifications(tx, insertID, p, mentions)
 	if err != nil {
 		return err
 	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 	p.ID = insertID
+	p.Mentions = mentions
+
+	// 購読者へ配信
+	pr.st.Hub.Publish(append([]HubEvent{{Type: HubEventSweet, UserID: p.UserID, PostID: p.ID}}, events...)...)
+
+	// 検索対象へ登録
+	// 保存と配信は済んでいるので, 失敗してもエラーにしない
+	if err := pr.st.Search.Index(p); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+// postIDで登録したpの返信先の投稿者とメンションしたユーザへ通知する
+// 返信先の投稿者をメンションしている場合は返信の通知のみ行う
+// コミット後にHubへ配信するイベントを返す
+func entryPostNotifications(tx *Tx, postID int64, p *Post, mentions map[string]int64) ([]HubEvent, error) {
+	var events []HubEvent
+	var parentUserID int64
+	if p.InReplyTo != 0 {
+		err := tx.QueryRow("SELECT p.user_id FROM posts p WHERE p.id = ?", p.InReplyTo).Scan(&parentUserID)
+		if err != nil {
+			return nil, err
+		}
+		e, err := entryNotification(tx, parentUserID, p.UserID, NotificationReply, postID)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			events = append(events, *e)
+		}
+	}
+	for _, userID := range mentions {
+		if userID == parentUserID {
+			continue
+		}
+		e, err := entryNotification(tx, userID, p.UserID, NotificationMention, postID)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			events = append(events, *e)
+		}
+	}
+	return events, nil
+}
+
+// ValidateEdit はuserIDがpのメッセージをmessageへ編集する前のチェックを行う
+// pはFindByIDで取得したもの
+func (p *Post) ValidateEdit(userID int64, message string) error {
+	var messages []string
+
+	// 投稿者本人のみ編集できる
+	if p.UserID != userID || p.Deleted == true || p.IsResweet() == true {
+		messages = append(messages, "このすいーとは編集出来ません")
+	} else if p.IsEditable(time.Now()) == false {
+		messages = append(messages, fmt.Sprintf("すいーとは投稿から%d分以内のみ編集出来ます", int(PostEditableDuration/time.Minute)))
+	}
+
+	// 投稿メッセージの文字数チェック
+	if n := utf8.RuneCountInString(message); n < 1 || 140 < n {
+		messages = append(messages, "投稿は1文字以上, 140字以内で行ってください")
+	}
+
+	// エラーメッセージを登録しておく
+	p.Messages = messages
 
 	return nil
 }
 
-// Sweets はuserIDのタイムラインに表示されるSweetを取得する
-func Sweets(userID int64, limit int, offset int) ([]Post, error) {
-	// コネクション取得
-	db, err := DBConnection()
+// Edit はpのメッセージをmessageへ変更する
+// 変更前のメッセージはpost_revisionsへ残す
+func (pr *SQLPostRepository) Edit(p *Post, message string) error {
+	db := pr.st.db
+
+	tx, err := db.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	// SQL発行
-	rows, err := db.Query(`
-		(SELECT
+	defer tx.Rollback()
+
+	// 変更前のメッセージを残す
+	// created_atはそのメッセージを書いた日時
+	writtenAt := p.CreatedAt
+	if p.IsEdited() == true {
+		writtenAt = p.EditedAt
+	}
+	_, err = tx.Exec("INSERT INTO post_revisions(post_id, message, created_at) VALUES(?, ?, ?)", p.ID, p.Message, writtenAt)
+	if err != nil {
+		return err
+	}
+
+	// メッセージを変更
+	editedAt := time.Now()
+	_, err = tx.Exec(`
+		UPDATE
+			posts
+		SET
+			message = ?,
+			edited_at = ?
+		WHERE
+			id = ?
+		AND
+			deleted_at IS NULL
+	`, message, editedAt, p.ID)
+	if err != nil {
+		return err
+	}
+
+	// メンションを登録し直す
+	_, err = tx.Exec("DELETE FROM post_mentions WHERE post_id = ?", p.ID)
+	if err != nil {
+		return err
+	}
+	mentions, err := entryMentions(tx, p.ID, message)
+	if err != nil {
+		return err
+	}
+	// ハッシュタグを登録し直す
+	_, err = tx.Exec("DELETE FROM post_tags WHERE post_id = ?", p.ID)
+	if err != nil {
+		return err
+	}
+	if err := entryTags(tx, p.ID, message, p.CreatedAt); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	p.Message = message
+	p.EditedAt = editedAt
+	p.Mentions = mentions
+
+	// 検索対象を更新
+	// 変更は済んでいるので, 失敗してもエラーにしない
+	if err := pr.st.Search.Index(p); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+// Remove はuserIDが投稿したpを削除済にする
+// 他のユーザのsweetは削除しない. 削除した場合はtrueを返す
+func (pr *SQLPostRepository) Remove(p *Post, userID int64) (bool, error) {
+	db := pr.st.db
+
+	// プリペアードステートメント生成
+	stmt, err := db.Prepare(`
+		UPDATE
+			posts
+		SET
+			deleted_at = ?
+		WHERE
+			id = ?
+		AND
+			user_id = ?
+		AND
+			deleted_at IS NULL
+	`)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	// クエリ発行
+	result, err := stmt.Exec(time.Now(), p.ID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	p.Deleted = n > 0
+	if n == 0 {
+		return false, nil
+	}
+
+	// 検索対象から外す
+	// 削除済のsweetは検索結果からも除くので, 失敗してもエラーにしない
+	if err := pr.st.Search.Remove(p.ID); err != nil {
+		log.Println(err)
+	}
+	return true, nil
+}
+
+// postSelectColumns はPostを取得する際のSELECT句
+// postFromTablesと組み合わせて使い, scanPostsで読み込む
+func postSelectColumns(d Dialect) string {
+	return `
 			p.id,
 			p.user_id,
 			u.name,
 			p.message,
-			p.created_at
-		FROM
+			p.in_reply_to,
+			p.resweet_of,
+			(SELECT COUNT(*) FROM posts r WHERE r.in_reply_to = p.id AND r.deleted_at IS NULL) AS reply_count,
+			(SELECT COUNT(*) FROM likes l WHERE l.post_id = p.id) AS like_count,
+			p.created_at,
+			p.edited_at,
+			p.deleted_at,
+			` + postMentionsColumn(d, "p.id") + ` AS mentions,
+			o.user_id AS original_user_id,
+			ou.name AS original_user_name,
+			o.message AS original_message,
+			(SELECT COUNT(*) FROM posts r WHERE r.in_reply_to = o.id AND r.deleted_at IS NULL) AS original_reply_count,
+			(SELECT COUNT(*) FROM likes l WHERE l.post_id = o.id) AS original_like_count,
+			o.created_at AS original_created_at,
+			o.edited_at AS original_edited_at,
+			o.deleted_at AS original_deleted_at,
+			` + postMentionsColumn(d, "o.id") + ` AS original_mentions`
+}
+
+// postVisibleCond は一覧に表示するPostの条件
+// 削除済のsweetと, リスイート元が削除済のリスイートを除く
+const postVisibleCond = `
+			p.deleted_at IS NULL
+		AND
+			(p.resweet_of IS NULL OR p.message <> '' OR o.deleted_at IS NULL)`
+
+// postFromTables はPostを取得する際のFROM句
+// 投稿者とリスイート元の投稿, その投稿者を結合する
+const postFromTables = `
 			posts p
 		INNER JOIN
 			users u
 		ON
 			p.user_id = u.id
+		LEFT JOIN
+			posts o
+		ON
+			p.resweet_of = o.id
+		LEFT JOIN
+			users ou
+		ON
+			o.user_id = ou.id`
+
+// FindByID はidに一致するPostを探して, pの内容を置き換える
+func (pr *SQLPostRepository) FindByID(p *Post, id int64) (bool, error) {
+	db := pr.st.db
+
+	// クエリ発行
+	rows, err := db.Query(`
+	SELECT`+postSelectColumns(db.dialect)+`
+	FROM`+postFromTables+`
+	WHERE
+		p.id = ?
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	posts, err := scanPosts(rows)
+	if err != nil {
+		return false, err
+	}
+	// 存在判定
+	if len(posts) == 0 {
+		return false, nil
+	}
+	*p = posts[0]
+	return true, nil
+}
+
+// FindByIDs はidsのPostのうちviewerIDに表示出来るものをidsの順に取得する
+// 削除済のものは含めない
+func (pr *SQLPostRepository) FindByIDs(viewerID int64, ids []int64) ([]Post, error) {
+	if len(ids) == 0 {
+		return make([]Post, 0), nil
+	}
+	db := pr.st.db
+	// パラメータ組み立て
+	placeholders := make([]string, 0, len(ids))
+	idArgs := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		placeholders = append(placeholders, "?")
+		idArgs = append(idArgs, id)
+	}
+	cond := (&sqlConds{}).add(`
+			p.id IN (`+strings.Join(placeholders, ", ")+`)`, idArgs...).visibleTo(viewerID)
+
+	// SQL発行
+	found, err := pr.st.queryPosts(`
+		SELECT`+postSelectColumns(db.dialect)+`
+		FROM`+postFromTables+`
+		WHERE`+cond.String()+`
+	`, cond.args)
+	if err != nil {
+		return nil, err
+	}
+	// idsの順に並べ直す
+	byID := make(map[int64]Post, len(found))
+	for _, p := range found {
+		byID[p.ID] = p
+	}
+	posts := make([]Post, 0, len(found))
+	for _, id := range ids {
+		if p, ok := byID[id]; ok == true {
+			posts = append(posts, p)
+		}
+	}
+	return posts, nil
+}
+
+// TimelineCursor はタイムラインのページ位置を表すカーソル
+// (created_at, id)の組で投稿を一意に順序付ける
+// SQLiteとPostgreSQLは秒未満も保存するので, created_atはナノ秒まで受け渡す
+type TimelineCursor struct {
+	CreatedAt time.Time
+	ID        int64
+}
+
+// Cursor はpの位置を表すカーソルを返す
+func (p *Post) Cursor() *TimelineCursor {
+	return &TimelineCursor{CreatedAt: p.CreatedAt, ID: p.ID}
+}
+
+// LikeCursor はいいね一覧でのpの位置を表すカーソルを返す
+func (p *Post) LikeCursor() *TimelineCursor {
+	return &TimelineCursor{CreatedAt: p.LikedAt, ID: p.ID}
+}
+
+// String はURLで受け渡すためのカーソル文字列を返す
+func (c *TimelineCursor) String() string {
+	return fmt.Sprintf("%d-%d", c.CreatedAt.UnixNano(), c.ID)
+}
+
+// カーソル文字列を解析する
+// 空文字列の場合はnilを返す
+func parseTimelineCursor(str string) (*TimelineCursor, error) {
+	if str == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(str, "-", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid timeline cursor: " + str)
+	}
+	nsec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &TimelineCursor{CreatedAt: time.Unix(0, nsec), ID: id}, nil
+}
+
+// sqlConds はWHERE句の条件と, そのプレースホルダへ渡す引数を同じ順に集めたもの
+// 条件ごとに引数を渡すので, 条件を足しても他の条件の引数の位置はずれない
+type sqlConds struct {
+	conds []string
+	args  []interface{}
+}
+
+// add はcondとそのプレースホルダへ渡すargsを追加する
+// プレースホルダとargsの数が合わなければpanicする
+func (c *sqlConds) add(cond string, args ...interface{}) *sqlConds {
+	if n := strings.Count(cond, "?"); n != len(args) {
+		panic(fmt.Sprintf("sql condition has %d placeholders but %d args:%s", n, len(args), cond))
+	}
+	c.conds = append(c.conds, cond)
+	c.args = append(c.args, args...)
+	return c
+}
+
+// String はANDでつないだ条件を返す. 条件がなければ常に真の条件を返す
+func (c *sqlConds) String() string {
+	if len(c.conds) == 0 {
+		return `
+			1 = 1`
+	}
+	return strings.Join(c.conds, `
+		AND`)
+}
+
+// olderThan は(column, idColumn)の組がcurより古い条件を追加する. curがnilなら何もしない
+func (c *sqlConds) olderThan(column string, idColumn string, cur *TimelineCursor) *sqlConds {
+	if cur == nil {
+		return c
+	}
+	return c.add(`
+			(`+column+` < ? OR (`+column+` = ? AND `+idColumn+` < ?))`, cur.CreatedAt, cur.CreatedAt, cur.ID)
+}
+
+// newerThan は(column, idColumn)の組がcurより新しい条件を追加する. curがnilなら何もしない
+func (c *sqlConds) newerThan(column string, idColumn string, cur *TimelineCursor) *sqlConds {
+	if cur == nil {
+		return c
+	}
+	return c.add(`
+			(`+column+` > ? OR (`+column+` = ? AND `+idColumn+` > ?))`, cur.CreatedAt, cur.CreatedAt, cur.ID)
+}
+
+// timelineResweetCond はタイムラインで重複するリスイートを除く条件
+// リスイート元の投稿か, それより前の同じ投稿のリスイートがタイムラインに含まれていれば除く
+// 削除済のものやミュート, ブロックしているユーザのものはタイムラインに含まれないので数えない
+// プレースホルダにはタイムラインのユーザIDを5つ渡す
+var timelineResweetCond = `
+			NOT EXISTS (
+				SELECT
+					1
+				FROM
+					posts d
+				WHERE
+					p.resweet_of IS NOT NULL
+				AND
+					p.message = ''
+				AND
+					(d.id = p.resweet_of
+					OR (d.resweet_of = p.resweet_of
+						AND d.message = ''
+						AND (d.created_at < p.created_at OR (d.created_at = p.created_at AND d.id < p.id))))
+				AND
+					(d.user_id = ? OR d.user_id IN (SELECT tf.followee_user_id FROM followers tf WHERE tf.follower_user_id = ?))
+				AND
+					d.deleted_at IS NULL
+				AND` + mutedUsersCond("d.user_id") + `
+				AND` + blockedUsersCond("d.user_id") + `
+			)`
+
+// mutedUsersCond はuserColumnsのいずれかを閲覧者がミュートしていれば偽になる条件を返す
+// プレースホルダには閲覧者のユーザIDを1つ渡す
+func mutedUsersCond(userColumns string) string {
+	return `
+			NOT EXISTS (
+				SELECT
+					1
+				FROM
+					mutes m
+				WHERE
+					m.user_id = ?
+				AND
+					m.muted_user_id IN (` + userColumns + `)
+			)`
+}
+
+// blockedUsersCond はuserColumnsのいずれかと閲覧者がブロック関係にあれば偽になる条件を返す
+// ブロックはどちらからのものでも対象にする
+// プレースホルダには閲覧者のユーザIDを2つ渡す
+func blockedUsersCond(userColumns string) string {
+	return `
+			NOT EXISTS (
+				SELECT
+					1
+				FROM
+					blocks b
+				WHERE
+					(b.user_id = ? AND b.blocked_user_id IN (` + userColumns + `))
+				OR
+					(b.blocked_user_id = ? AND b.user_id IN (` + userColumns + `))
+			)`
+}
+
+// postMutedCond は閲覧者がミュートしているユーザのPostを除く条件
+// リスイート元の投稿者も対象にする
+// プレースホルダには閲覧者のユーザIDを1つ渡す
+var postMutedCond = mutedUsersCond("p.user_id, o.user_id")
+
+// postBlockedCond は閲覧者とブロック関係にあるユーザのPostを除く条件
+// リスイート元の投稿者も対象にする
+// プレースホルダには閲覧者のユーザIDを2つ渡す
+var postBlockedCond = blockedUsersCond("p.user_id, o.user_id")
+
+// postAuthorProtectedCond は閲覧者が閲覧出来ない鍵アカウントの投稿を除く条件
+// 自分の投稿とフォローしているユーザの投稿は閲覧出来る
+// プレースホルダには閲覧者のユーザIDを2つ渡す
+const postAuthorProtectedCond = `
+			(u.protected = FALSE
+			OR p.user_id = ?
+			OR p.user_id IN (SELECT af.followee_user_id FROM followers af WHERE af.follower_user_id = ?))`
+
+// postOriginalProtectedCond は閲覧者が閲覧出来ない鍵アカウントの投稿をリスイート元とするPostを除く条件
+// プレースホルダには閲覧者のユーザIDを2つ渡す
+const postOriginalProtectedCond = `
+			(o.user_id IS NULL
+			OR ou.protected = FALSE
+			OR o.user_id = ?
+			OR o.user_id IN (SELECT pf.followee_user_id FROM followers pf WHERE pf.follower_user_id = ?))`
+
+// timeline はuserIDのタイムラインに表示するPostの条件を追加する
+// タイムラインの投稿者は自分かフォローしているユーザなので, 鍵アカウントはリスイート元のみ確認する
+func (c *sqlConds) timeline(userID int64) *sqlConds {
+	return c.add(postVisibleCond).
+		add(timelineResweetCond, userID, userID, userID, userID, userID).
+		add(postMutedCond, userID).
+		add(postBlockedCond, userID, userID).
+		add(postOriginalProtectedCond, userID, userID)
+}
+
+// visibleTo はviewerIDの閲覧する一覧に表示するPostの条件を追加する
+// 削除済のsweetと, ブロック関係にあるユーザや閲覧出来ない鍵アカウントのPostを除く
+func (c *sqlConds) visibleTo(viewerID int64) *sqlConds {
+	return c.add(postVisibleCond).
+		add(postBlockedCond, viewerID, viewerID).
+		add(postAuthorProtectedCond, viewerID, viewerID).
+		add(postOriginalProtectedCond, viewerID, viewerID)
+}
+
+// タイムライン取得用のSQLと引数を組み立てる
+// フォローしているユーザの投稿と自分の投稿のそれぞれでcondによる絞り込みとLIMITを行い,
+// OFFSETで読み飛ばさずに済むようにする
+// condはposts pに対する条件でタイムラインの条件を追加する, orderはASCまたはDESC
+func sweetsQuery(d Dialect, userID int64, cond *sqlConds, order string, limit int) (string, []interface{}) {
+	cond.timeline(userID)
+	followees := fmt.Sprintf(`
+		SELECT`+postSelectColumns(d)+`
+		FROM`+postFromTables+`
 		INNER JOIN
 			followers f
 		ON
-			u.id = f.follower_id
+			u.id = f.followee_user_id
 		WHERE
-			f.user_id = ?)
-		UNION
-		(SELECT
-			p.id,
-			p.user_id,
-			u.name,
-			p.message,
-			p.created_at
-		FROM
-			posts p
-		INNER JOIN
-			users u
-		ON
-			p.user_id = u.id
+			f.follower_user_id = ?
+		AND%[1]s
+		ORDER BY
+			p.created_at %[2]s, p.id %[2]s
+		LIMIT ?`, cond, order)
+	own := fmt.Sprintf(`
+		SELECT`+postSelectColumns(d)+`
+		FROM`+postFromTables+`
 		WHERE
-			u.id = ?)
+			u.id = ?
+		AND%[1]s
 		ORDER BY
-			created_at desc
+			p.created_at %[2]s, p.id %[2]s
+		LIMIT ?`, cond, order)
+	query := d.Union(followees, own) + fmt.Sprintf(`
+		ORDER BY
+			created_at %[1]s, id %[1]s
 		LIMIT ?
-		OFFSET ?
-	`, userID, userID, limit, offset)
+	`, order)
+
+	// パラメータ組み立て
+	var args []interface{}
+	args = append(args, userID)
+	args = append(args, cond.args...)
+	args = append(args, limit, userID)
+	args = append(args, cond.args...)
+	args = append(args, limit, limit)
+	return query, args
+}
+
+// userIDのタイムラインに表示されるSweetを新しい順に取得する
+// beforeを指定した場合はそれより古いものだけを取得する
+func (pr *SQLPostRepository) sweets(userID int64, limit int, before *TimelineCursor) ([]Post, error) {
+	cond := (&sqlConds{}).olderThan("p.created_at", "p.id", before)
+	query, args := sweetsQuery(pr.st.db.dialect, userID, cond, "DESC", limit)
+	return pr.querySweets(userID, query, args)
+}
+
+// userIDのタイムラインでafterより新しいSweetを取得する
+// afterに近いものからlimit件を取得し, 新しい順に並べて返す
+func (pr *SQLPostRepository) sweetsAfter(userID int64, limit int, after *TimelineCursor) ([]Post, error) {
+	cond := (&sqlConds{}).newerThan("p.created_at", "p.id", after)
+	query, args := sweetsQuery(pr.st.db.dialect, userID, cond, "ASC", limit)
+	posts, err := pr.querySweets(userID, query, args)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	// 古い順で取得しているので反転
+	reverseSlice(posts)
+	return posts, nil
+}
+
+// TimelineSweet はpostIDのSweetがuserIDのタイムラインに表示されるものであれば取得する
+func (pr *SQLPostRepository) TimelineSweet(userID int64, postID int64) (Post, bool, error) {
+	cond := (&sqlConds{}).add(`
+			p.id = ?`, postID)
+	query, args := sweetsQuery(pr.st.db.dialect, userID, cond, "DESC", 1)
+	posts, err := pr.querySweets(userID, query, args)
+	if err != nil || len(posts) == 0 {
+		return Post{}, false, err
+	}
+	return posts[0], true, nil
+}
+
+// SweetsSinceID はuserIDのタイムラインでIDがsinceIDより大きいSweetを古い順にlimit件取得する
+func (pr *SQLPostRepository) SweetsSinceID(userID int64, sinceID int64, limit int) ([]Post, error) {
+	cond := (&sqlConds{}).add(`
+			p.id > ?`, sinceID)
+	query, args := sweetsQuery(pr.st.db.dialect, userID, cond, "ASC", limit)
+	return pr.querySweets(userID, query, args)
+}
+
+// sweetsQueryで組み立てたSQLを発行してPostのスライスを返す
+// userIDのいいねの有無も設定する
+func (pr *SQLPostRepository) querySweets(userID int64, query string, args []interface{}) ([]Post, error) {
+	posts, err := pr.st.queryPosts(query, args)
+	if err != nil {
+		return nil, err
+	}
+	// 閲覧者のいいねをまとめて取得
+	if err := pr.st.fillLikedByMe(userID, posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
 
+// postSelectColumnsの順に並んだ結果行をPostのスライスにする
+func scanPosts(rows *sql.Rows) ([]Post, error) {
+	return scanPostsWith(rows, nil)
+}
+
+// postSelectColumnsの後に列を追加した結果行をPostのスライスにする
+// extraは追加した列の読み込み先を返す関数
+func scanPostsWith(rows *sql.Rows, extra func(p *Post) []interface{}) ([]Post, error) {
 	posts := make([]Post, 0)
 	for rows.Next() {
 		var p Post
-		if err := rows.Scan(&p.ID, &p.UserID, &p.UserName, &p.Message, &p.CreatedAt); err != nil {
+		var inReplyTo, resweetOf sql.NullInt64
+		var o Post
+		var oUserID sql.NullInt64
+		var oUserName, oMessage, mentions, oMentions sql.NullString
+		var editedAt, deletedAt, oCreatedAt, oEditedAt, oDeletedAt sql.NullTime
+		dest := []interface{}{&p.ID, &p.UserID, &p.UserName, &p.Message, &inReplyTo, &resweetOf, &p.ReplyCount, &p.LikeCount,
+			&p.CreatedAt, &editedAt, &deletedAt, &mentions,
+			&oUserID, &oUserName, &oMessage, &o.ReplyCount, &o.LikeCount, &oCreatedAt, &oEditedAt, &oDeletedAt, &oMentions}
+		if extra != nil {
+			dest = append(dest, extra(&p)...)
+		}
+		if err := rows.Scan(dest...); err != nil {
 			return nil, err
 		}
+		p.InReplyTo = inReplyTo.Int64
+		p.ResweetOf = resweetOf.Int64
+		p.EditedAt = editedAt.Time
+		p.Deleted = deletedAt.Valid
+		p.Mentions = decodeMentions(mentions)
+		if p.ResweetOf != 0 {
+			o.ID = p.ResweetOf
+			o.UserID = oUserID.Int64
+			o.UserName = oUserName.String
+			o.Message = oMessage.String
+			o.CreatedAt = oCreatedAt.Time
+			o.EditedAt = oEditedAt.Time
+			o.Deleted = oDeletedAt.Valid
+			o.Mentions = decodeMentions(oMentions)
+			p.Original = &o
+		}
 		posts = append(posts, p)
 	}
-	return posts, nil
+	return posts, rows.Err()
+}
+
+// ユーザ個人の投稿一覧をviewerIDが閲覧するSQLと引数を組み立てる
+// condはposts pに対する条件, orderはASCまたはDESC
+func userSweetsQuery(d Dialect, userID int64, viewerID int64, cond *sqlConds, order string, limit int) (string, []interface{}) {
+	cond.visibleTo(viewerID)
+	query := fmt.Sprintf(`
+		SELECT`+postSelectColumns(d)+`
+		FROM`+postFromTables+`
+		WHERE
+			p.user_id = ?
+		AND%[1]s
+		ORDER BY
+			p.created_at %[2]s, p.id %[2]s
+		LIMIT ?
+	`, cond, order)
+
+	// パラメータ組み立て
+	var args []interface{}
+	args = append(args, userID)
+	args = append(args, cond.args...)
+	args = append(args, limit)
+	return query, args
+}
+
+// postSelectColumnsを取得するSQLを発行してPostのスライスを返す
+func (st *Store) queryPosts(query string, args []interface{}) ([]Post, error) {
+	db := st.db
+
+	// SQL発行
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPosts(rows)
+}
+
+// UserSweetsPage はuserIDが投稿したSweetのうちviewerIDに表示出来るものの1ページ分を取得する
+// 引数と戻り値はTimelinePageと同じ
+func (pr *SQLPostRepository) UserSweetsPage(userID int64, viewerID int64, limit int, before *TimelineCursor, after *TimelineCursor) ([]Post, *TimelineCursor, *TimelineCursor, error) {
+	return pageSweets(limit, before, after, (*Post).Cursor,
+		func(limit int, before *TimelineCursor) ([]Post, error) {
+			cond := (&sqlConds{}).olderThan("p.created_at", "p.id", before)
+			return pr.st.queryPosts(userSweetsQuery(pr.st.db.dialect, userID, viewerID, cond, "DESC", limit))
+		},
+		func(limit int, after *TimelineCursor) ([]Post, error) {
+			cond := (&sqlConds{}).newerThan("p.created_at", "p.id", after)
+			posts, err := pr.st.queryPosts(userSweetsQuery(pr.st.db.dialect, userID, viewerID, cond, "ASC", limit))
+			if err != nil {
+				return nil, err
+			}
+			reverseSlice(posts)
+			return posts, nil
+		})
+}
+
+// TimelinePage はuserIDのタイムラインの1ページ分を取得する
+// beforeとafterは高々一方のみ指定する. どちらもnilなら最新のページを返す
+// 前後のページがあればそのページを指すカーソルを返す
+func (pr *SQLPostRepository) TimelinePage(userID int64, limit int, before *TimelineCursor, after *TimelineCursor) ([]Post, *TimelineCursor, *TimelineCursor, error) {
+	return pageSweets(limit, before, after, (*Post).Cursor,
+		func(limit int, before *TimelineCursor) ([]Post, error) {
+			return pr.sweets(userID, limit, before)
+		},
+		func(limit int, after *TimelineCursor) ([]Post, error) {
+			return pr.sweetsAfter(userID, limit, after)
+		})
+}
+
+// 新しい順に並んだ一覧をカーソルで1ページ分取得する
+// fetchBeforeはカーソルより古いものを新しい順に, fetchAfterはカーソルより新しいものを新しい順に
+// 呼び出し側の一覧へ取得して件数を返す関数. afterを指定しなければfetchAfterはnilでよい
+// sliceは一覧を[i, j)に縮める関数, cursorAtは一覧のi番目の位置を返す関数
+// 戻り値はTimelinePageと同じく前後のページを指すカーソル
+func pageKeyset(limit int, before *TimelineCursor, after *TimelineCursor,
+	fetchBefore func(int, *TimelineCursor) (int, error),
+	fetchAfter func(int, *TimelineCursor) (int, error),
+	slice func(int, int),
+	cursorAt func(int) *TimelineCursor) (older *TimelineCursor, newer *TimelineCursor, err error) {
+	// 次のページの有無を確認するため1件多く取得する
+	if after != nil {
+		n, err := fetchAfter(limit+1, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		if n > limit {
+			slice(1, n)
+			n--
+			newer = cursorAt(0)
+		}
+		// afterより古いものは必ずある
+		older = after
+		if n > 0 {
+			older = cursorAt(n - 1)
+		}
+		return older, newer, nil
+	}
+
+	n, err := fetchBefore(limit+1, before)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n > limit {
+		slice(0, limit)
+		n = limit
+		older = cursorAt(n - 1)
+	}
+	// 最新ページ以外は新しい側へ戻れる
+	if before != nil {
+		newer = before
+		if n > 0 {
+			newer = cursorAt(0)
+		}
+	}
+	return older, newer, nil
+}
+
+// sweetの一覧をカーソルで1ページ分取得する
+// cursorOfは一覧の並び順でのPostの位置を返す関数
+// fetchBeforeはカーソルより古いものを新しい順に, fetchAfterはカーソルより新しいものを新しい順に返す関数
+func pageSweets(limit int, before *TimelineCursor, after *TimelineCursor,
+	cursorOf func(*Post) *TimelineCursor,
+	fetchBefore func(int, *TimelineCursor) ([]Post, error),
+	fetchAfter func(int, *TimelineCursor) ([]Post, error)) (posts []Post, older *TimelineCursor, newer *TimelineCursor, err error) {
+	older, newer, err = pageKeyset(limit, before, after,
+		func(limit int, before *TimelineCursor) (int, error) {
+			posts, err = fetchBefore(limit, before)
+			return len(posts), err
+		},
+		func(limit int, after *TimelineCursor) (int, error) {
+			posts, err = fetchAfter(limit, after)
+			return len(posts), err
+		},
+		func(i int, j int) { posts = posts[i:j] },
+		func(i int) *TimelineCursor { return cursorOf(&posts[i]) })
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return posts, older, newer, nil
+}
+
+// 新しい順に並んだスライスを古い順に並べ替える(またはその逆)
+// sliceには任意の型のスライスを渡す
+func reverseSlice(slice interface{}) {
+	swap := reflect.Swapper(slice)
+	for i, j := 0, reflect.ValueOf(slice).Len()-1; i < j; i, j = i+1, j-1 {
+		swap(i, j)
+	}
+}
+
+// newTimelineForTemplate はuserIDのタイムライン画面用のデータを作成する
+func (app *App) newTimelineForTemplate(userID int64, before *TimelineCursor, after *TimelineCursor) (*TimelineForTemplate, error) {
+	posts, older, newer, err := app.Posts.TimelinePage(userID, app.Config.TimelinePageLimit, before, after)
+	if err != nil {
+		return nil, err
+	}
+	timeline := &TimelineForTemplate{Sweets: posts}
+	if older != nil {
+		timeline.Older = older.String()
+	}
+	if newer != nil {
+		timeline.Newer = newer.String()
+	}
+	// サイドバーのトレンド
+	timeline.TrendingTags, err = app.TrendingTags(userID, time.Now().Add(-TrendingTagsPeriod), TrendingTagsLimit)
+	if err != nil {
+		return nil, err
+	}
+	timeline.FollowRequestCount, err = app.countFollowRequests(userID)
+	if err != nil {
+		return nil, err
+	}
+	timeline.UnreadCount, err = app.countUnreadNotifications(userID)
+	if err != nil {
+		return nil, err
+	}
+	return timeline, nil
+}
+
+// IsResweeted はuserIDがpostIDをリスイートしていればtrueを返す
+func (pr *SQLPostRepository) IsResweeted(userID int64, postID int64) (bool, error) {
+	db := pr.st.db
+
+	// クエリ発行
+	var count int64
+	err := db.QueryRow(`
+	SELECT
+		COUNT(*)
+	FROM
+		posts p
+	WHERE
+		p.user_id = ?
+	AND
+		p.resweet_of = ?
+	AND
+		p.message = ''
+	AND
+		p.deleted_at IS NULL
+	`, userID, postID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RemoveResweet はuserIDによるpostIDのリスイートを取り消す
+// Removeと同じく削除済にする. 引用すいーとは削除しない
+func (pr *SQLPostRepository) RemoveResweet(userID int64, postID int64) error {
+	db := pr.st.db
+
+	// 取り消すリスイートを取得
+	rows, err := db.Query(`
+		SELECT
+			id
+		FROM
+			posts
+		WHERE
+			user_id = ?
+		AND
+			resweet_of = ?
+		AND
+			message = ''
+		AND
+			deleted_at IS NULL
+	`, userID, postID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if _, err := pr.Remove(&Post{ID: id}, userID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimelineCursorString(t *testing.T) {
+	c := &TimelineCursor{CreatedAt: time.Date(2026, 10, 16, 12, 34, 56, 123456789, time.Local), ID: 42}
+	parsed, err := parseTimelineCursor(c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.CreatedAt.Equal(c.CreatedAt) == false || parsed.ID != c.ID {
+		t.Errorf("parseTimelineCursor(%q) = %+v, want %+v", c.String(), parsed, c)
+	}
+
+	if c, err := parseTimelineCursor(""); c != nil || err != nil {
+		t.Errorf(`parseTimelineCursor("") = %+v, %v`, c, err)
+	}
+	for _, str := range []string{"123", "a-1", "123-b"} {
+		if _, err := parseTimelineCursor(str); err == nil {
+			t.Errorf("parseTimelineCursor(%q) succeeded", str)
+		}
+	}
+}
+
+// 同じ秒に投稿されたsweetも取りこぼさずにページをたどれる
+func TestTimelinePageSameSecond(t *testing.T) {
+	st := newTestStore(t)
+	alice := newTestUser(t, st, "alice")
+
+	// 1ミリ秒ずつずらして同じ秒に6件投稿する
+	base := time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local)
+	var want []int64
+	for i := 0; i < 6; i++ {
+		p := newTestPost(t, st, alice.ID, "sweet")
+		if _, err := st.db.Exec("UPDATE posts SET created_at = ? WHERE id = ?", base.Add(time.Duration(i)*time.Millisecond), p.ID); err != nil {
+			t.Fatal(err)
+		}
+		want = append([]int64{p.ID}, want...)
+	}
+
+	// カーソルは文字列にしてから戻して使う
+	roundTrip := func(c *TimelineCursor) *TimelineCursor {
+		if c == nil {
+			return nil
+		}
+		parsed, err := parseTimelineCursor(c.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	// 古い側へたどる
+	var got []int64
+	var before, newest *TimelineCursor
+	for page := 0; ; page++ {
+		if page > len(want) {
+			t.Fatal("too many pages")
+		}
+		posts, older, newer, err := st.Posts.TimelinePage(alice.ID, 2, before, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(posts) == 0 {
+			t.Fatalf("page %d is empty after %v", page, got)
+		}
+		got = append(got, postIDs(posts)...)
+		newest = roundTrip(newer)
+		if older == nil {
+			break
+		}
+		before = roundTrip(older)
+	}
+	if reflect.DeepEqual(got, want) == false {
+		t.Errorf("older pages = %v, want %v", got, want)
+	}
+
+	// 最後のページから新しい側へ戻る
+	got = nil
+	after := newest
+	for page := 0; after != nil; page++ {
+		if page > len(want) {
+			t.Fatal("too many pages")
+		}
+		posts, _, newer, err := st.Posts.TimelinePage(alice.ID, 2, nil, after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(posts) == 0 {
+			t.Fatalf("page %d is empty after %v", page, got)
+		}
+		got = append(postIDs(posts), got...)
+		after = roundTrip(newer)
+	}
+	if reflect.DeepEqual(got, want[:len(want)-2]) == false {
+		t.Errorf("newer pages = %v, want %v", got, want[:len(want)-2])
+	}
+}
+
+// recordingSearchBackend は検索対象から外したsweetのIDを記録するSearchBackend
+type recordingSearchBackend struct {
+	SearchBackend
+	removed []int64
+}
+
+// Remove は外したIDを記録してSearchBackendへ委譲する
+func (sb *recordingSearchBackend) Remove(postID int64) error {
+	sb.removed = append(sb.removed, postID)
+	return sb.SearchBackend.Remove(postID)
+}
+
+// タイムラインに含まれない先のリスイートで後のリスイートを重複扱いにしない
+func TestTimelineResweetDedupe(t *testing.T) {
+	st := newTestStore(t)
+	search := &recordingSearchBackend{SearchBackend: st.Search}
+	st.Search = search
+	alice := newTestUser(t, st, "alice")
+	dave := newTestUser(t, st, "dave")
+	var resweeters []*User
+	for _, name := range []string{"bob", "carol", "erin", "frank"} {
+		u := newTestUser(t, st, name)
+		newTestFollow(t, st, alice.ID, u.ID)
+		resweeters = append(resweeters, u)
+	}
+	bob, carol, erin := resweeters[0], resweeters[1], resweeters[2]
+
+	// aliceのフォローしていないdaveのsweetを4人が順にリスイートする
+	o := newTestPost(t, st, dave.ID, "sweet")
+	var resweets []int64
+	for _, u := range resweeters {
+		r := &Post{UserID: u.ID, ResweetOf: o.ID}
+		if err := st.Posts.Entry(r); err != nil {
+			t.Fatal(err)
+		}
+		resweets = append(resweets, r.ID)
+	}
+	check := func(name string, want int64) {
+		t.Helper()
+		posts, _, _, err := st.Posts.TimelinePage(alice.ID, 10, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := postIDs(posts); reflect.DeepEqual(got, []int64{want}) == false {
+			t.Errorf("%s: TimelinePage = %v, want [%d]", name, got, want)
+		}
+	}
+	check("first resweet", resweets[0])
+
+	// 取り消したリスイートは削除済にして検索対象から外す
+	if err := st.Posts.RemoveResweet(bob.ID, o.ID); err != nil {
+		t.Fatal(err)
+	}
+	var removed Post
+	if exist, err := st.Posts.FindByID(&removed, resweets[0]); err != nil || exist == false || removed.Deleted == false {
+		t.Errorf("FindByID(removed resweet) = %v, %v, %+v", exist, err, removed)
+	}
+	if reflect.DeepEqual(search.removed, resweets[:1]) == false {
+		t.Errorf("removed from search = %v, want %v", search.removed, resweets[:1])
+	}
+	if resweeted, err := st.Posts.IsResweeted(bob.ID, o.ID); err != nil || resweeted == true {
+		t.Errorf("IsResweeted after RemoveResweet = %v, %v", resweeted, err)
+	}
+	again := &Post{UserID: bob.ID, ResweetOf: o.ID}
+	if err := again.Validate(st); err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Messages) != 0 {
+		t.Errorf("Validate(resweet again) = %q", again.Messages)
+	}
+	check("removed resweet", resweets[1])
+
+	if err := (&Mute{UserID: alice.ID, TargetID: carol.ID}).Entry(st); err != nil {
+		t.Fatal(err)
+	}
+	check("muted resweeter", resweets[2])
+
+	if err := (&Block{UserID: alice.ID, TargetID: erin.ID}).Entry(st); err != nil {
+		t.Fatal(err)
+	}
+	check("blocked resweeter", resweets[3])
+
+	// sweetとして削除したリスイートもリスイート済としない
+	frank := resweeters[3]
+	if removed, err := st.Posts.Remove(&Post{ID: resweets[3]}, frank.ID); err != nil || removed == false {
+		t.Fatalf("Remove = %v, %v", removed, err)
+	}
+	if resweeted, err := st.Posts.IsResweeted(frank.ID, o.ID); err != nil || resweeted == true {
+		t.Errorf("IsResweeted after Remove = %v, %v", resweeted, err)
+	}
+}
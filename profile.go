@@ -0,0 +1,194 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ProfileForTemplate はユーザページ表示用のデータ構造
+type ProfileForTemplate struct {
+	User           User
+	IsMe           bool  // 閲覧者自身のページであればtrue
+	Following      bool  // 閲覧者がフォローしていればtrue
+	Requested      bool  // 閲覧者がフォローリクエストしていればtrue
+	Hidden         bool  // 鍵アカウントで閲覧者がsweetを閲覧出来なければtrue
+	Blocking       bool  // 閲覧者がブロックしていればtrue
+	Muting         bool  // 閲覧者がミュートしていればtrue
+	FollowingCount int64 // フォローしている人数
+	FollowerCount  int64 // フォローされている人数
+	RequestCount   int64 // 閲覧者自身への承認待ちのフォローリクエストの数
+	Sweets         []Post
+	Older          string // より古いページのカーソル. なければ空
+	Newer          string // より新しいページのカーソル. なければ空
+}
+
+// [/users/{id}]以下のパスを分解する
+// /users/12 なら12と空文字列, /users/12/likes なら12と"likes"を返す
+func parseUserPath(path string) (int64, string, bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/users/"), "/"), "/")
+	if len(parts) == 0 || len(parts) > 2 {
+		return 0, "", false
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	if len(parts) == 1 {
+		return id, "", true
+	}
+	return id, parts[1], true
+}
+
+// [/users/]以下のハンドラ
+func (app *App) usersHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	id, sub, ok := parseUserPath(r.URL.Path)
+	if ok == false {
+		http.NotFound(w, r)
+		return
+	}
+	switch sub {
+	case "":
+		app.profileHandler(w, r, s, id)
+	case "likes":
+		app.likesHandler(w, r, s, id)
+	case "following":
+		app.followsHandler(w, r, s, id, false)
+	case "followers":
+		app.followsHandler(w, r, s, id, true)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// [/users/{id}]のハンドラ
+func (app *App) profileHandler(w http.ResponseWriter, r *http.Request, s *Session, id int64) {
+	// GET以外は存在しない
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.FormValue("before"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+	after, err := parseTimelineCursor(r.FormValue("after"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+
+	// ユーザ情報の取得
+	pft := &ProfileForTemplate{IsMe: uid == id}
+	exist, err := app.Users.FindByID(&pft.User, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if exist == false {
+		http.NotFound(w, r)
+		return
+	}
+
+	// フォロー情報の取得
+	pft.FollowingCount, pft.FollowerCount, err = app.Followers.Count(id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if pft.IsMe == false {
+		pft.Following, err = app.Followers.IsFollowing(uid, id)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+			return
+		}
+		pft.Blocking, err = app.isBlocking(uid, id)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+			return
+		}
+		pft.Muting, err = app.isMuting(uid, id)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+			return
+		}
+		if pft.User.Protected == true && pft.Following == false {
+			pft.Hidden = true
+			pft.Requested, err = app.isFollowRequested(uid, id)
+			if err != nil {
+				log.Println(err)
+				http.Error(w, "Sorry.", http.StatusInternalServerError)
+				return
+			}
+		}
+	} else {
+		pft.RequestCount, err = app.countFollowRequests(uid)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// 鍵アカウントのsweetは承認したフォロワーにのみ表示する
+	if pft.Hidden == true {
+		err = responseTemplate.ExecuteTemplate(w, "profile.tmpl", pft)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// sweetsの取得
+	posts, older, newer, err := app.Posts.UserSweetsPage(id, uid, app.Config.TimelinePageLimit, before, after)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if err := app.fillLikedByMe(uid, posts); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	pft.Sweets = posts
+	if older != nil {
+		pft.Older = older.String()
+	}
+	if newer != nil {
+		pft.Newer = newer.String()
+	}
+
+	err = responseTemplate.ExecuteTemplate(w, "profile.tmpl", pft)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+	}
+}
+
+// フォーム値redirect_toのページへリダイレクトする
+// 指定がないかサイト外を指している場合はfallbackへリダイレクトする
+func redirectBack(w http.ResponseWriter, r *http.Request, fallback string) {
+	to := r.FormValue("redirect_to")
+	if strings.HasPrefix(to, "/") == false || strings.HasPrefix(to, "//") == true || strings.Contains(to, "\\") == true {
+		to = fallback
+	}
+	http.Redirect(w, r, to, http.StatusFound)
+}
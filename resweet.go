@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// [/resweet]のハンドラ
+func (app *App) resweetHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は存在しない
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// リスイートするsweetを取得
+	postID, err := strconv.ParseInt(r.FormValue("post_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+
+	// 入力チェック
+	post := &Post{UserID: uid, ResweetOf: postID}
+	if err := post.Validate(app.Store); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if len(post.Messages) > 0 {
+		// リスイート元のスレッドでエラーを表示
+		t, exist, err := app.findThread(postID)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+			return
+		}
+		if exist == false {
+			http.NotFound(w, r)
+			return
+		}
+		app.renderThread(w, t, uid, post.Messages)
+		return
+	}
+	// 登録
+	if err := app.Posts.Entry(post); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// 指定がなければリスイート元のスレッドへ回す
+	redirectBack(w, r, threadPath(postID))
+}
+
+// [/unresweet]のハンドラ
+func (app *App) unresweetHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// POST以外は存在しない
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// リスイートを取り消すsweetを取得
+	postID, err := strconv.ParseInt(r.FormValue("post_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+
+	// リスイートを削除
+	if err := app.Posts.RemoveResweet(uid, postID); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// 指定がなければリスイート元のスレッドへ回す
+	redirectBack(w, r, threadPath(postID))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"time"
+)
+
+// PostRevision は編集前のsweetのメッセージ
+type PostRevision struct {
+	ID        int64
+	PostID    int64
+	Message   string
+	CreatedAt time.Time // このメッセージを書いた日時
+}
+
+// postIDの編集履歴を新しい順に返す
+func (st *Store) findPostRevisions(postID int64) ([]PostRevision, error) {
+	db := st.db
+	// SQL発行
+	rows, err := db.Query(`
+		SELECT
+			r.id,
+			r.post_id,
+			r.message,
+			r.created_at
+		FROM
+			post_revisions r
+		WHERE
+			r.post_id = ?
+		ORDER BY
+			r.created_at desc, r.id desc
+	`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]PostRevision, 0)
+	for rows.Next() {
+		var r PostRevision
+		if err := rows.Scan(&r.ID, &r.PostID, &r.Message, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SearchDateLayout はsince:, until:で指定する日付の形式
+const SearchDateLayout = "2006-01-02"
+
+// SearchQuery はsweet検索の条件
+// 例: `東京 "スカイ ツリー" from:bob since:2026-01-01 until:2026-01-31`
+type SearchQuery struct {
+	Raw         string    // 入力された検索文字列
+	Terms       []string  // 全て含むべき語
+	Phrases     []string  // 全て含むべき語句. "..."で囲んだもの
+	From        string    // from:で指定した投稿者のユーザ名
+	FromUserIDs []int64   // Fromの名前を持つユーザのID. 検索時に設定する
+	Since       time.Time // この日時以降に投稿されたもの. ゼロ値なら指定なし
+	Until       time.Time // この日時より前に投稿されたもの. ゼロ値なら指定なし
+	Messages    []string  // エラーメッセージ
+	since       string    // since:で指定した日付. Validateで解釈する
+	until       string    // until:で指定した日付. Validateで解釈する
+}
+
+// SearchForTemplate は検索画面用のデータ構造
+type SearchForTemplate struct {
+	Query  *SearchQuery
+	Sweets []Post
+	Older  string // より古いページのカーソル. なければ空
+}
+
+// parseSearchQuery は検索文字列を分解する
+// 空白区切りで語を, "..."で語句を, from:, since:, until:で絞り込みを指定する
+func parseSearchQuery(raw string) *SearchQuery {
+	q := &SearchQuery{Raw: raw}
+	rest := raw
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			break
+		}
+		// 語句
+		if strings.HasPrefix(rest, `"`) == true {
+			end := strings.Index(rest[1:], `"`)
+			var phrase string
+			if end < 0 {
+				phrase, rest = rest[1:], ""
+			} else {
+				phrase, rest = rest[1:end+1], rest[end+2:]
+			}
+			if phrase = strings.TrimSpace(phrase); phrase != "" {
+				q.Phrases = append(q.Phrases, phrase)
+			}
+			continue
+		}
+		// 語
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return unicode.IsSpace(r) || r == '"'
+		})
+		var word string
+		if end < 0 {
+			word, rest = rest, ""
+		} else {
+			word, rest = rest[:end], rest[end:]
+		}
+		switch {
+		case strings.HasPrefix(word, "from:") && len(word) > len("from:"):
+			q.From = strings.TrimPrefix(strings.TrimPrefix(word, "from:"), "@")
+		case strings.HasPrefix(word, "since:") && len(word) > len("since:"):
+			q.since = strings.TrimPrefix(word, "since:")
+		case strings.HasPrefix(word, "until:") && len(word) > len("until:"):
+			q.until = strings.TrimPrefix(word, "until:")
+		default:
+			q.Terms = append(q.Terms, word)
+		}
+	}
+	return q
+}
+
+// Validate は検索前の入力チェックを行う
+// since:, until:の日付を解釈してSince, Untilを設定する
+func (q *SearchQuery) Validate() error {
+	var messages []string
+
+	// 検索文字列の文字数チェック
+	if n := utf8.RuneCountInString(q.Raw); 200 < n {
+		messages = append(messages, "検索文字列は200字以内で入力してください")
+	}
+
+	// 条件の有無チェック
+	if len(q.Terms) == 0 && len(q.Phrases) == 0 && q.From == "" {
+		messages = append(messages, "検索する語句またはfrom:を指定してください")
+	}
+
+	// 日付の形式チェック
+	// until:はその日の終わりまでを含める
+	if q.since != "" {
+		t, err := time.ParseInLocation(SearchDateLayout, q.since, time.Local)
+		if err != nil {
+			messages = append(messages, "since:の日付はYYYY-MM-DDの形式で入力してください")
+		}
+		q.Since = t
+	}
+	if q.until != "" {
+		t, err := time.ParseInLocation(SearchDateLayout, q.until, time.Local)
+		if err != nil {
+			messages = append(messages, "until:の日付はYYYY-MM-DDの形式で入力してください")
+		} else {
+			q.Until = t.AddDate(0, 0, 1)
+		}
+	}
+	if q.Since.IsZero() == false && q.Until.IsZero() == false && q.Since.Before(q.Until) == false {
+		messages = append(messages, "since:の日付はuntil:の日付以前にしてください")
+	}
+
+	q.Messages = messages
+	return nil
+}
+
+// SearchPage はqに一致するSweetのうちviewerIDに表示出来るものの1ページ分を新しい順に取得する
+// 古い側のページがあればそのページを指すカーソルを返す
+func (st *Store) SearchPage(q *SearchQuery, viewerID int64, limit int, before *TimelineCursor) ([]Post, *TimelineCursor, error) {
+	// 投稿者の絞り込みはユーザIDで行う
+	if q.From != "" {
+		ids, err := st.Users.FindIDsByName(q.From)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(ids) == 0 {
+			return make([]Post, 0), nil, nil
+		}
+		q.FromUserIDs = ids
+	}
+
+	// 次のページの有無を確認するため1件多く取得する
+	// 表示出来ないsweetを除くと足りなければ, 続きから検索し直す
+	posts := make([]Post, 0)
+	cursors := make(map[int64]TimelineCursor)
+	for {
+		found, err := st.Search.Search(q, limit+1, before)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids := make([]int64, 0, len(found))
+		for _, c := range found {
+			ids = append(ids, c.ID)
+			cursors[c.ID] = c
+		}
+		visible, err := st.Posts.FindByIDs(viewerID, ids)
+		if err != nil {
+			return nil, nil, err
+		}
+		posts = append(posts, visible...)
+		if len(posts) > limit || len(found) <= limit {
+			break
+		}
+		before = &found[len(found)-1]
+	}
+
+	// カーソルは検索の実装が返したものを使う
+	var older *TimelineCursor
+	if len(posts) > limit {
+		posts = posts[:limit]
+		c := cursors[posts[limit-1].ID]
+		older = &c
+	}
+	return posts, older, nil
+}
+
+// [/search]のハンドラ
+func (app *App) searchHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は存在しない
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+
+	// ページ位置を取得
+	before, err := parseTimelineCursor(r.FormValue("before"))
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+
+	// 検索文字列がなければフォームのみ表示
+	q := parseSearchQuery(r.FormValue("q"))
+	sft := &SearchForTemplate{Query: q}
+	if strings.TrimSpace(q.Raw) != "" {
+		// 入力チェック
+		if err := q.Validate(); err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+			return
+		}
+		// 検索
+		if len(q.Messages) == 0 {
+			posts, older, err := app.SearchPage(q, uid, app.Config.TimelinePageLimit, before)
+			if err != nil {
+				log.Println(err)
+				http.Error(w, "Sorry.", http.StatusInternalServerError)
+				return
+			}
+			if err := app.fillLikedByMe(uid, posts); err != nil {
+				log.Println(err)
+				http.Error(w, "Sorry.", http.StatusInternalServerError)
+				return
+			}
+			sft.Sweets = posts
+			if older != nil {
+				sft.Older = older.String()
+			}
+		}
+	}
+
+	err = responseTemplate.ExecuteTemplate(w, "search.tmpl", sft)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+	}
+}
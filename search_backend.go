@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// SearchBackend はsweet検索の実装
+type SearchBackend interface {
+	// Search はqに一致するsweetの位置をbeforeより古いものから新しい順に最大limit件返す
+	// beforeがnilなら最新のものから返す
+	Search(q *SearchQuery, limit int, before *TimelineCursor) ([]TimelineCursor, error)
+	// Index はpを検索対象に登録する. 既に登録されていれば置き換える
+	Index(p *Post) error
+	// Remove はpostIDのsweetを検索対象から外す
+	Remove(postID int64) error
+}
+
+// qの語と語句を検索用の文字列のスライスにする
+func searchNeedles(q *SearchQuery) []string {
+	needles := make([]string, 0, len(q.Terms)+len(q.Phrases))
+	needles = append(needles, q.Terms...)
+	needles = append(needles, q.Phrases...)
+	return needles
+}
+
+// MySQLSearchBackend はpostsテーブルのFULLTEXTインデックスで検索する
+// 日本語を扱うためngramパーサを使う. ngram_token_size(既定は2)より短い語は検索できない
+type MySQLSearchBackend struct {
+	db *DB
+}
+
+// NewMySQLSearchBackend はdbで検索するMySQLSearchBackendを生成して返す
+func NewMySQLSearchBackend(db *DB) *MySQLSearchBackend {
+	return &MySQLSearchBackend{db: db}
+}
+
+// 語と語句をBOOLEAN MODEの検索文字列にする
+// 全て必須とし, 演算子として解釈されないよう"で囲む
+func mysqlBooleanQuery(needles []string) string {
+	parts := make([]string, 0, len(needles))
+	for _, n := range needles {
+		parts = append(parts, `+"`+strings.Replace(n, `"`, "", -1)+`"`)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Search はqに一致するsweetの位置を新しい順に返す
+func (ms *MySQLSearchBackend) Search(q *SearchQuery, limit int, before *TimelineCursor) ([]TimelineCursor, error) {
+	db := ms.db
+
+	// 条件組み立て
+	// リスイートはメッセージが空なので対象にならない
+	conds := []string{"p.deleted_at IS NULL", "p.message <> ''"}
+	var args []interface{}
+	if needles := searchNeedles(q); len(needles) > 0 {
+		conds = append(conds, "MATCH(p.message) AGAINST(? IN BOOLEAN MODE)")
+		args = append(args, mysqlBooleanQuery(needles))
+	}
+	if len(q.FromUserIDs) > 0 {
+		placeholders := make([]string, 0, len(q.FromUserIDs))
+		for _, id := range q.FromUserIDs {
+			placeholders = append(placeholders, "?")
+			args = append(args, id)
+		}
+		conds = append(conds, "p.user_id IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if q.Since.IsZero() == false {
+		conds = append(conds, "p.created_at >= ?")
+		args = append(args, q.Since)
+	}
+	if q.Until.IsZero() == false {
+		conds = append(conds, "p.created_at < ?")
+		args = append(args, q.Until)
+	}
+	if before != nil {
+		conds = append(conds, "(p.created_at < ? OR (p.created_at = ? AND p.id < ?))")
+		args = append(args, before.CreatedAt, before.CreatedAt, before.ID)
+	}
+	args = append(args, limit)
+
+	// SQL発行
+	rows, err := db.Query(`
+		SELECT
+			p.created_at,
+			p.id
+		FROM
+			posts p
+		WHERE
+			`+strings.Join(conds, "\n\t\tAND\n\t\t\t")+`
+		ORDER BY
+			p.created_at DESC, p.id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make([]TimelineCursor, 0)
+	for rows.Next() {
+		var c TimelineCursor
+		if err := rows.Scan(&c.CreatedAt, &c.ID); err != nil {
+			return nil, err
+		}
+		found = append(found, c)
+	}
+	return found, rows.Err()
+}
+
+// Index はFULLTEXTインデックスが自動で更新されるので何もしない
+func (ms *MySQLSearchBackend) Index(p *Post) error {
+	return nil
+}
+
+// Remove はFULLTEXTインデックスが自動で更新されるので何もしない
+func (ms *MySQLSearchBackend) Remove(postID int64) error {
+	return nil
+}
+
+// MemorySearchBackend はプロセス内の転置インデックスで検索する
+// 正規化したメッセージの2文字ずつ(bi-gram)を索引にする
+// 起動時にDBから全てのsweetを読み込むので, 複数のプロセスでは投稿が反映されない
+type MemorySearchBackend struct {
+	docs     map[int64]*memorySearchDocument
+	postings map[string]map[int64]bool // bi-gramを含むsweetのID
+	lock     sync.RWMutex
+	db       *DB // Rebuildで読み込むDB
+}
+
+// 検索対象のsweet
+type memorySearchDocument struct {
+	userID    int64
+	createdAt time.Time
+	text      string // 正規化したメッセージ
+}
+
+// NewMemorySearchBackend は空のMemorySearchBackendを生成して返す
+// RebuildではdbのPostを読み込む
+func NewMemorySearchBackend(db *DB) *MemorySearchBackend {
+	return &MemorySearchBackend{
+		docs:     make(map[int64]*memorySearchDocument),
+		postings: make(map[string]map[int64]bool),
+		db:       db,
+	}
+}
+
+// 検索用にテキストを正規化する
+// 全角英数字や半角カナを揃え, 英字は小文字にする
+func normalizeSearchText(text string) string {
+	return strings.ToLower(norm.NFKC.String(text))
+}
+
+// 正規化したテキストのbi-gramを重複なく返す. 空白を含むものは除く
+func searchBigrams(text string) []string {
+	runes := []rune(text)
+	grams := make([]string, 0, len(runes))
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(runes); i++ {
+		if unicode.IsSpace(runes[i]) == true || unicode.IsSpace(runes[i+1]) == true {
+			continue
+		}
+		g := string(runes[i : i+2])
+		if seen[g] == false {
+			seen[g] = true
+			grams = append(grams, g)
+		}
+	}
+	return grams
+}
+
+// Rebuild はDBの削除済でないsweetから索引を作り直す
+func (ms *MemorySearchBackend) Rebuild() error {
+	db := ms.db
+	// SQL発行
+	rows, err := db.Query(`
+		SELECT
+			p.id,
+			p.user_id,
+			p.message,
+			p.created_at
+		FROM
+			posts p
+		WHERE
+			p.deleted_at IS NULL
+		AND
+			p.message <> ''
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	docs := make(map[int64]*memorySearchDocument)
+	postings := make(map[string]map[int64]bool)
+	for rows.Next() {
+		var id int64
+		var message string
+		doc := &memorySearchDocument{}
+		if err := rows.Scan(&id, &doc.userID, &message, &doc.createdAt); err != nil {
+			return err
+		}
+		doc.text = normalizeSearchText(message)
+		docs[id] = doc
+		for _, g := range searchBigrams(doc.text) {
+			if postings[g] == nil {
+				postings[g] = make(map[int64]bool)
+			}
+			postings[g][id] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	ms.docs = docs
+	ms.postings = postings
+	return nil
+}
+
+// Search はqに一致するsweetの位置を新しい順に返す
+func (ms *MemorySearchBackend) Search(q *SearchQuery, limit int, before *TimelineCursor) ([]TimelineCursor, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	needles := searchNeedles(q)
+	for i := range needles {
+		needles[i] = normalizeSearchText(needles[i])
+	}
+	fromUsers := make(map[int64]bool)
+	for _, id := range q.FromUserIDs {
+		fromUsers[id] = true
+	}
+
+	// 最も少ないbi-gramの索引から候補を絞る
+	// 1文字の語しかなければ全件を候補にする
+	var candidates map[int64]bool
+	for _, n := range needles {
+		for _, g := range searchBigrams(n) {
+			ids := ms.postings[g]
+			if len(ids) == 0 {
+				return make([]TimelineCursor, 0), nil
+			}
+			if candidates == nil || len(ids) < len(candidates) {
+				candidates = ids
+			}
+		}
+	}
+
+	found := make([]TimelineCursor, 0)
+	match := func(id int64, doc *memorySearchDocument) {
+		for _, n := range needles {
+			if strings.Contains(doc.text, n) == false {
+				return
+			}
+		}
+		if len(fromUsers) > 0 && fromUsers[doc.userID] == false {
+			return
+		}
+		if q.Since.IsZero() == false && doc.createdAt.Before(q.Since) == true {
+			return
+		}
+		if q.Until.IsZero() == false && doc.createdAt.Before(q.Until) == false {
+			return
+		}
+		if before != nil && (doc.createdAt.Before(before.CreatedAt) == true ||
+			(doc.createdAt.Equal(before.CreatedAt) == true && id < before.ID)) == false {
+			return
+		}
+		found = append(found, TimelineCursor{CreatedAt: doc.createdAt, ID: id})
+	}
+	if candidates == nil {
+		for id, doc := range ms.docs {
+			match(id, doc)
+		}
+	} else {
+		for id := range candidates {
+			match(id, ms.docs[id])
+		}
+	}
+
+	// 新しい順に並べる
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].CreatedAt.Equal(found[j].CreatedAt) == true {
+			return found[i].ID > found[j].ID
+		}
+		return found[i].CreatedAt.After(found[j].CreatedAt)
+	})
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+// Index はpを索引へ登録する
+// 削除済のsweetやリスイートは索引から外す
+func (ms *MemorySearchBackend) Index(p *Post) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	ms.remove(p.ID)
+	if p.Deleted == true || p.Message == "" {
+		return nil
+	}
+	doc := &memorySearchDocument{userID: p.UserID, createdAt: p.CreatedAt, text: normalizeSearchText(p.Message)}
+	ms.docs[p.ID] = doc
+	for _, g := range searchBigrams(doc.text) {
+		if ms.postings[g] == nil {
+			ms.postings[g] = make(map[int64]bool)
+		}
+		ms.postings[g][p.ID] = true
+	}
+	return nil
+}
+
+// Remove はpostIDのsweetを索引から外す
+func (ms *MemorySearchBackend) Remove(postID int64) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	ms.remove(postID)
+	return nil
+}
+
+// postIDのsweetを索引から外す. lockを取得してから呼ぶ
+func (ms *MemorySearchBackend) remove(postID int64) {
+	doc, ok := ms.docs[postID]
+	if ok == false {
+		return
+	}
+	for _, g := range searchBigrams(doc.text) {
+		delete(ms.postings[g], postID)
+		if len(ms.postings[g]) == 0 {
+			delete(ms.postings, g)
+		}
+	}
+	delete(ms.docs, postID)
+}
+
+// 設定に応じたSearchBackendを生成する
+// SearchBackendが未指定の場合はMySQLならFULLTEXTインデックス, それ以外はメモリ上の索引で検索する
+func newSearchBackend(config *Config, db *DB) (SearchBackend, error) {
+	backend := config.SearchBackend
+	if backend == "" {
+		backend = "memory"
+		if db.dialect.Name() == "mysql" {
+			backend = "mysql"
+		}
+	}
+	switch backend {
+	case "mysql":
+		if db.dialect.Name() != "mysql" {
+			return nil, errors.New("search backend mysql requires db driver mysql")
+		}
+		return NewMySQLSearchBackend(db), nil
+	case "memory":
+		ms := NewMemorySearchBackend(db)
+		if err := ms.Rebuild(); err != nil {
+			return nil, err
+		}
+		return ms, nil
+	default:
+		return nil, errors.New("unknown search backend: " + config.SearchBackend)
+	}
+}
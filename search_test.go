@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		raw     string
+		terms   []string
+		phrases []string
+		from    string
+	}{
+		{"東京 タワー", []string{"東京", "タワー"}, nil, ""},
+		{`東京 "スカイ ツリー" 浅草`, []string{"東京", "浅草"}, []string{"スカイ ツリー"}, ""},
+		{`"スカイ ツリー""東京 タワー"`, nil, []string{"スカイ ツリー", "東京 タワー"}, ""},
+		{`東京"タワー"`, []string{"東京"}, []string{"タワー"}, ""},
+		// 閉じていない"は末尾までを語句とする
+		{`東京 "スカイ ツリー`, []string{"東京"}, []string{"スカイ ツリー"}, ""},
+		{`"  "`, nil, nil, ""},
+		// from:は@を付けても付けなくてもよい
+		{"from:bob", nil, nil, "bob"},
+		{"from:@bob 東京", []string{"東京"}, nil, "bob"},
+		// 値のないfrom:は語として扱う
+		{"from:", []string{"from:"}, nil, ""},
+		{"  ", nil, nil, ""},
+	}
+	for _, tt := range tests {
+		q := parseSearchQuery(tt.raw)
+		if reflect.DeepEqual(q.Terms, tt.terms) == false {
+			t.Errorf("parseSearchQuery(%q).Terms = %q, want %q", tt.raw, q.Terms, tt.terms)
+		}
+		if reflect.DeepEqual(q.Phrases, tt.phrases) == false {
+			t.Errorf("parseSearchQuery(%q).Phrases = %q, want %q", tt.raw, q.Phrases, tt.phrases)
+		}
+		if q.From != tt.from {
+			t.Errorf("parseSearchQuery(%q).From = %q, want %q", tt.raw, q.From, tt.from)
+		}
+	}
+}
+
+func TestSearchQueryValidate(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.ParseInLocation(SearchDateLayout, s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tests := []struct {
+		raw   string
+		valid bool
+		since time.Time
+		until time.Time
+	}{
+		{"東京", true, time.Time{}, time.Time{}},
+		{"from:bob", true, time.Time{}, time.Time{}},
+		{"since:2026-01-01 until:2026-01-31 東京", true, day("2026-01-01"), day("2026-02-01")},
+		// 同じ日の指定はその1日
+		{"since:2026-01-31 until:2026-01-31 東京", true, day("2026-01-31"), day("2026-02-01")},
+		{"since:2026-02-01 until:2026-01-31 東京", false, day("2026-02-01"), day("2026-02-01")},
+		{"since:2026/01/01 東京", false, time.Time{}, time.Time{}},
+		{"until:yesterday 東京", false, time.Time{}, time.Time{}},
+		// 語句もfrom:もない
+		{"since:2026-01-01", false, day("2026-01-01"), time.Time{}},
+		{"", false, time.Time{}, time.Time{}},
+	}
+	for _, tt := range tests {
+		q := parseSearchQuery(tt.raw)
+		if err := q.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if valid := len(q.Messages) == 0; valid != tt.valid {
+			t.Errorf("Validate(%q) valid = %v, want %v (%q)", tt.raw, valid, tt.valid, q.Messages)
+		}
+		if q.Since.Equal(tt.since) == false || q.Until.Equal(tt.until) == false {
+			t.Errorf("Validate(%q) = [%v, %v), want [%v, %v)", tt.raw, q.Since, q.Until, tt.since, tt.until)
+		}
+	}
+
+	// until:の日の終わりまでを含める
+	q := parseSearchQuery("until:2026-01-31 東京")
+	if err := q.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if last := day("2026-01-31").Add(24*time.Hour - time.Nanosecond); last.Before(q.Until) == false {
+		t.Errorf("until:2026-01-31 excludes %v", last)
+	}
+	if next := day("2026-02-01"); next.Before(q.Until) == true {
+		t.Errorf("until:2026-01-31 includes %v", next)
+	}
+}
+
+func TestSearchQueryValidateLength(t *testing.T) {
+	raw := ""
+	for i := 0; i < 201; i++ {
+		raw += "あ"
+	}
+	q := parseSearchQuery(raw)
+	if err := q.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Messages) == 0 {
+		t.Errorf("Validate accepts %d characters", 201)
+	}
+}
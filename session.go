@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"sync"
@@ -16,15 +20,17 @@ type Session struct {
 	expireTime time.Time
 	data       map[interface{}]interface{}
 	lock       sync.Mutex
+	store      SessionStore
 }
 
 // Set はセッションに対しデータを設定します
 func (s *Session) Set(key interface{}, value interface{}) error {
 	s.lock.Lock()
-	defer s.lock.Unlock()
-
 	s.data[key] = value
-	return nil
+	s.lock.Unlock()
+
+	// 変更をストアへ反映
+	return s.save()
 }
 
 // Get はセッションからkeyに一致するデータを取得します
@@ -39,10 +45,11 @@ func (s *Session) Get(key interface{}) (interface{}, error) {
 // Delete はセッションからkeyに一致するデータを削除します
 func (s *Session) Delete(key interface{}) error {
 	s.lock.Lock()
-	defer s.lock.Unlock()
-
 	delete(s.data, key)
-	return nil
+	s.lock.Unlock()
+
+	// 変更をストアへ反映
+	return s.save()
 }
 
 // SessionID はセッションのIDを返します
@@ -50,19 +57,57 @@ func (s *Session) SessionID() string {
 	return s.sessionID
 }
 
+// セッションの内容をストアへ保存する
+func (s *Session) save() error {
+	if s.store == nil {
+		return nil
+	}
+	return s.store.Save(s)
+}
+
+// セッションデータをストア保存用にシリアライズする
+func (s *Session) encodeData() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s.data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// シリアライズされたセッションデータを復元する
+func decodeSessionData(b []byte) (map[interface{}]interface{}, error) {
+	data := make(map[interface{}]interface{})
+	if len(b) == 0 {
+		return data, nil
+	}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 // SessionManager はセッション全体を管理するオブジェクト
 type SessionManager struct {
 	cookieName string
-	sessions   map[string]*Session
+	store      SessionStore
 	lock       sync.Mutex
 	maxAge     int
+	gcTimer    *time.Timer // 次のGCのタイマー
+	gcStopped  bool        // StopGCを呼んだらtrue
 }
 
 // NewSessionManager は新しいセッションマネージャを生成して返す
-func NewSessionManager(cookieName string, maxAge int) (*SessionManager, error) {
+// セッションの保存はstoreへ委譲する
+func NewSessionManager(cookieName string, maxAge int, store SessionStore) (*SessionManager, error) {
+	if store == nil {
+		return nil, errors.New("session store is nil")
+	}
 	mgr := &SessionManager{
 		cookieName: cookieName,
-		sessions:   make(map[string]*Session),
+		store:      store,
 		maxAge:     maxAge,
 	}
 	return mgr, nil
@@ -89,10 +134,13 @@ func (mgr *SessionManager) IsSessionStarted(w http.ResponseWriter, r *http.Reque
 	}
 
 	// セッションチェック
-	v, ok := mgr.sessions[sid]
+	v, err := mgr.store.Load(sid)
+	if err != nil {
+		return false, nil, err
+	}
 
-	// セッションがなかった
-	if ok == false {
+	// セッションがなかった, もしくは有効期限切れ
+	if v == nil || time.Now().After(v.expireTime) == true {
 		return false, nil, nil
 	}
 
@@ -135,8 +183,11 @@ func (mgr *SessionManager) SessionStart(w http.ResponseWriter, r *http.Request)
 		sessionID:  sid,
 		expireTime: time.Now().Add(time.Duration(mgr.maxAge) * time.Second),
 		data:       make(map[interface{}]interface{}),
+		store:      mgr.store,
+	}
+	if err := mgr.store.Save(s); err != nil {
+		return nil, err
 	}
-	mgr.sessions[sid] = s
 
 	return s, nil
 }
@@ -157,7 +208,9 @@ func (mgr *SessionManager) SessionEnd(w http.ResponseWriter, r *http.Request) er
 	if err != nil {
 		return err
 	}
-	delete(mgr.sessions, sid)
+	if err := mgr.store.Delete(sid); err != nil {
+		return err
+	}
 
 	// セッションを破棄したいので有効期限を現在にする
 	newCookie := http.Cookie{
@@ -182,13 +235,24 @@ func (mgr *SessionManager) GC() {
 	// 現在時刻
 	t := time.Now()
 
-	// 現在のセッションを全て確認し, 生存期間を超えているものを削除
-	for k, s := range mgr.sessions {
-		if t.After(s.expireTime) == true {
-			delete(mgr.sessions, k)
-		}
+	// 生存期間を超えているものをストアから削除
+	if err := mgr.store.GC(t); err != nil {
+		log.Println(err)
 	}
 
 	// 次の実行を設定
-	time.AfterFunc(time.Duration(mgr.maxAge), func() { mgr.GC() })
+	if mgr.gcStopped == false {
+		mgr.gcTimer = time.AfterFunc(time.Duration(mgr.maxAge)*time.Second, func() { mgr.GC() })
+	}
+}
+
+// StopGC はGCのタイマーを止める. 実行中のGCがあれば終わるのを待つ
+func (mgr *SessionManager) StopGC() {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.gcStopped = true
+	if mgr.gcTimer != nil {
+		mgr.gcTimer.Stop()
+	}
 }
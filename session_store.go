@@ -0,0 +1,338 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// SessionStore はSessionManagerが利用するセッションの保存先
+type SessionStore interface {
+	// Load はsidに一致するセッションを返す. 存在しなければnilを返す
+	Load(sid string) (*Session, error)
+	// Save はセッションを保存する. 既に存在すれば上書きする
+	Save(s *Session) error
+	// Delete はsidに一致するセッションを削除する
+	Delete(sid string) error
+	// GC は時刻tの時点で有効期限を過ぎたセッションを削除する
+	GC(t time.Time) error
+}
+
+// セッションIDとして妥当な形式(sessionIDで生成される16進数)
+var sessionIDPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// MemorySessionStore はプロセス内のメモリへセッションを保存する
+// プロセスが終了するとセッションは失われる
+type MemorySessionStore struct {
+	sessions map[string]*Session
+	lock     sync.Mutex
+}
+
+// NewMemorySessionStore は新しいMemorySessionStoreを生成して返す
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Load はsidに一致するセッションを返す
+func (ms *MemorySessionStore) Load(sid string) (*Session, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	s, _ := ms.sessions[sid]
+	return s, nil
+}
+
+// Save はセッションを保存する
+func (ms *MemorySessionStore) Save(s *Session) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	ms.sessions[s.sessionID] = s
+	return nil
+}
+
+// Delete はsidに一致するセッションを削除する
+func (ms *MemorySessionStore) Delete(sid string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	delete(ms.sessions, sid)
+	return nil
+}
+
+// GC は有効期限を過ぎたセッションを削除する
+func (ms *MemorySessionStore) GC(t time.Time) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	for k, s := range ms.sessions {
+		if t.After(s.expireTime) == true {
+			delete(ms.sessions, k)
+		}
+	}
+	return nil
+}
+
+// DBSessionStore はDBのsessionsテーブルへセッションを保存する
+// 複数のプロセスでセッションを共有できる
+type DBSessionStore struct {
+	db *DB
+}
+
+// NewDBSessionStore はdbへ保存するDBSessionStoreを生成して返す
+func NewDBSessionStore(db *DB) *DBSessionStore {
+	return &DBSessionStore{db: db}
+}
+
+// Load はsidに一致するセッションを返す
+func (ds *DBSessionStore) Load(sid string) (*Session, error) {
+	db := ds.db
+
+	// クエリ発行
+	var dbData []byte
+	var dbExpireTime time.Time
+	err := db.QueryRow(`
+	SELECT
+		s.data,
+		s.expire_time
+	FROM
+		sessions s
+	WHERE
+		s.id = ?
+	`, sid).Scan(&dbData, &dbExpireTime)
+
+	// 存在判定
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	data, err := decodeSessionData(dbData)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		sessionID:  sid,
+		expireTime: dbExpireTime,
+		data:       data,
+		store:      ds,
+	}, nil
+}
+
+// Save はセッションを保存する
+func (ds *DBSessionStore) Save(s *Session) error {
+	data, err := s.encodeData()
+	if err != nil {
+		return err
+	}
+
+	db := ds.db
+
+	// プリペアードステートメント生成
+	stmt, err := db.Prepare(db.dialect.Upsert("sessions", "id", []string{"data", "expire_time"}))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// クエリ発行
+	_, err = stmt.Exec(s.sessionID, data, s.expireTime)
+	return err
+}
+
+// Delete はsidに一致するセッションを削除する
+func (ds *DBSessionStore) Delete(sid string) error {
+	db := ds.db
+
+	// クエリ発行
+	_, err := db.Exec("DELETE FROM sessions WHERE id = ?", sid)
+	return err
+}
+
+// GC は有効期限を過ぎたセッションを削除する
+func (ds *DBSessionStore) GC(t time.Time) error {
+	db := ds.db
+
+	// クエリ発行
+	_, err := db.Exec("DELETE FROM sessions WHERE expire_time < ?", t)
+	return err
+}
+
+// FileSessionStore はディレクトリ内へセッション毎に1ファイルとして保存する
+type FileSessionStore struct {
+	dir  string
+	lock sync.Mutex
+}
+
+// ファイルへ書き出すセッション情報
+type fileSessionRecord struct {
+	ExpireTime time.Time
+	Data       []byte
+}
+
+// NewFileSessionStore はdirへ保存するFileSessionStoreを生成して返す
+// dirが存在しなければ作成する
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{dir: dir}, nil
+}
+
+// セッションIDに対応するファイルパスを返す
+// 不正なIDでディレクトリ外を指さないようにチェックする
+func (fs *FileSessionStore) path(sid string) (string, error) {
+	if sessionIDPattern.MatchString(sid) == false {
+		return "", errors.New("invalid session id")
+	}
+	return filepath.Join(fs.dir, sid), nil
+}
+
+// ファイルからセッション情報を読み出す
+func (fs *FileSessionStore) read(path string) (*fileSessionRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rec fileSessionRecord
+	if err := gob.NewDecoder(f).Decode(&rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// Load はsidに一致するセッションを返す
+func (fs *FileSessionStore) Load(sid string) (*Session, error) {
+	// 形式が不正なIDはセッションなしとして扱う
+	path, err := fs.path(sid)
+	if err != nil {
+		return nil, nil
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	rec, err := fs.read(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	data, err := decodeSessionData(rec.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		sessionID:  sid,
+		expireTime: rec.ExpireTime,
+		data:       data,
+		store:      fs,
+	}, nil
+}
+
+// Save はセッションを保存する
+func (fs *FileSessionStore) Save(s *Session) error {
+	path, err := fs.path(s.sessionID)
+	if err != nil {
+		return err
+	}
+	data, err := s.encodeData()
+	if err != nil {
+		return err
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	// 書きかけのファイルを読まれないよう一時ファイルからrenameする
+	tmp, err := ioutil.TempFile(fs.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	rec := fileSessionRecord{ExpireTime: s.expireTime, Data: data}
+	if err := gob.NewEncoder(tmp).Encode(&rec); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Delete はsidに一致するセッションを削除する
+func (fs *FileSessionStore) Delete(sid string) error {
+	path, err := fs.path(sid)
+	if err != nil {
+		return nil
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if err := os.Remove(path); err != nil && os.IsNotExist(err) == false {
+		return err
+	}
+	return nil
+}
+
+// GC は有効期限を過ぎたセッションを削除する
+func (fs *FileSessionStore) GC(t time.Time) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	files, err := ioutil.ReadDir(fs.dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range files {
+		if info.IsDir() == true || sessionIDPattern.MatchString(info.Name()) == false {
+			continue
+		}
+		path := filepath.Join(fs.dir, info.Name())
+		rec, err := fs.read(path)
+		if err != nil {
+			// 読めないファイルは壊れているものとして削除
+			os.Remove(path)
+			continue
+		}
+		if t.After(rec.ExpireTime) == true {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+// 設定に応じたSessionStoreを生成する
+// SessionStoreが未指定の場合はメモリへ保存する. mysqlの場合はstのDBを使う
+func newSessionStore(config *Config, st *Store) (SessionStore, error) {
+	switch config.SessionStore {
+	case "", "memory":
+		return NewMemorySessionStore(), nil
+	case "db", "mysql":
+		return NewDBSessionStore(st.db), nil
+	case "file":
+		dir := config.SessionDir
+		if dir == "" {
+			dir = "./sessions"
+		}
+		return NewFileSessionStore(dir)
+	default:
+		return nil, errors.New("unknown session store: " + config.SessionStore)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+const (
+	// DefaultDBMaxOpenConns は設定がない場合のDBへの最大接続数
+	DefaultDBMaxOpenConns = 25
+	// DefaultDBMaxIdleConns は設定がない場合に待機させておく接続の最大数
+	DefaultDBMaxIdleConns = 25
+	// DefaultDBConnMaxLifetime は設定がない場合に1つの接続を使い続ける最大の時間
+	DefaultDBConnMaxLifetime = 5 * time.Minute
+)
+
+// UserRepository はユーザの保存先
+type UserRepository interface {
+	// Exists はidのユーザが存在すればtrueを返す
+	Exists(id int64) (bool, error)
+	// EmailExists はemailのユーザが存在すればtrueを返す
+	EmailExists(email string) (bool, error)
+	// FindByID はidでユーザを探して, uの内容を置き換える
+	FindByID(u *User, id int64) (bool, error)
+	// FindByEmail はemailでユーザを探して, uの内容を置き換える
+	FindByEmail(u *User, email string) (bool, error)
+	// FindIDsByName はnameという名前のユーザのID一覧を返す
+	FindIDsByName(name string) ([]int64, error)
+	// Entry はuを新規登録し, 登録したIDをuへ設定する
+	Entry(u *User) error
+	// UpdatePassword はuのパスワードをpasswordでハッシュし直して更新する
+	UpdatePassword(u *User, password string) error
+}
+
+// PostRepository はsweetの保存先
+type PostRepository interface {
+	// FindByID はidでsweetを探して, pの内容を置き換える
+	FindByID(p *Post, id int64) (bool, error)
+	// FindByIDs はidsのsweetのうちviewerIDに表示出来るものをidsの順に返す. 削除済のものは含めない
+	FindByIDs(viewerID int64, ids []int64) ([]Post, error)
+	// Entry はpを新規登録し, 登録したIDをpへ設定する
+	Entry(p *Post) error
+	// Edit はpのメッセージをmessageへ変更する
+	Edit(p *Post, message string) error
+	// Remove はuserIDが投稿したpを削除済にする. 削除した場合はtrueを返す
+	Remove(p *Post, userID int64) (bool, error)
+	// IsResweeted はuserIDがpostIDをリスイートしていればtrueを返す
+	IsResweeted(userID int64, postID int64) (bool, error)
+	// RemoveResweet はuserIDによるpostIDのリスイートを取り消す
+	RemoveResweet(userID int64, postID int64) error
+	// TimelinePage はuserIDのタイムラインの1ページ分と前後のページのカーソルを返す
+	TimelinePage(userID int64, limit int, before *TimelineCursor, after *TimelineCursor) ([]Post, *TimelineCursor, *TimelineCursor, error)
+	// TimelineSweet はpostIDのsweetがuserIDのタイムラインに表示されるものであれば返す
+	TimelineSweet(userID int64, postID int64) (Post, bool, error)
+	// SweetsSinceID はuserIDのタイムラインでIDがsinceIDより大きいsweetを古い順にlimit件返す
+	SweetsSinceID(userID int64, sinceID int64, limit int) ([]Post, error)
+	// UserSweetsPage はuserIDが投稿したsweetのうちviewerIDに表示出来るものの1ページ分と前後のページのカーソルを返す
+	UserSweetsPage(userID int64, viewerID int64, limit int, before *TimelineCursor, after *TimelineCursor) ([]Post, *TimelineCursor, *TimelineCursor, error)
+}
+
+// FollowerRepository はフォローの保存先
+type FollowerRepository interface {
+	// Entry はfを登録する. 鍵アカウントへはフォローリクエストを登録する
+	Entry(f *Follow) error
+	// Remove はfと承認待ちのフォローリクエストを削除する
+	Remove(f *Follow) error
+	// IsFollowing はfollowerIDがfolloweeIDをフォローしていればtrueを返す
+	IsFollowing(followerID int64, followeeID int64) (bool, error)
+	// Count はuserIDのフォロー数とフォロワー数を返す
+	Count(userID int64) (int64, int64, error)
+	// Page はuserIDのフォロー(followersがtrueならフォロワー)一覧の1ページ分と前後のページのカーソルを返す
+	Page(userID int64, followers bool, viewerID int64, limit int, before *TimelineCursor, after *TimelineCursor) ([]FollowUser, *TimelineCursor, *TimelineCursor, error)
+	// Search はqueryを名前に含むユーザを返す
+	Search(viewerID int64, query string, limit int, offset int) ([]FollowUser, error)
+}
+
+// Store はDBへの接続プールと各リポジトリをまとめたもの
+// 起動時に1つだけ生成し, ハンドラやモデルへ渡して使う
+// ユーザ, sweet, フォローはリポジトリを通して読み書きする
+// ブロック, ミュート, フォローリクエスト, 通知, タグなどはStoreのメソッドがdbへ直接SQLを発行する
+type Store struct {
+	db        *DB
+	Users     UserRepository
+	Posts     PostRepository
+	Followers FollowerRepository
+	Search    SearchBackend
+	Hub       *Hub // 投稿などのイベントの配信先
+}
+
+// OpenStore はconfigのDBDriverとその接続先へ接続してStoreを生成する
+// AutoMigrateが設定されていれば未適用のマイグレーションを適用する. 使い終わったらCloseを呼ぶ
+func OpenStore(config *Config) (*Store, error) {
+	db, err := openDB(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.AutoMigrate == true {
+		done, err := MigrateUp(db)
+		for _, m := range done {
+			log.Println("migrated:", m.Name)
+		}
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	st := NewStore(db)
+	st.Search, err = newSearchBackend(config, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+// configのDBDriverとその接続先へ接続する
+// 接続数の設定がなければDefaultDBMaxOpenConnsなどを使う
+func openDB(config *Config) (*DB, error) {
+	dialect, err := newDialect(config)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sql.Open(dialect.DriverName(), dialect.DSN(config))
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{DB: conn, dialect: dialect}
+
+	// 接続プールの設定
+	maxOpen := config.DBMaxOpenConns
+	if maxOpen == 0 {
+		maxOpen = DefaultDBMaxOpenConns
+	}
+	maxIdle := config.DBMaxIdleConns
+	if maxIdle == 0 {
+		maxIdle = DefaultDBMaxIdleConns
+	}
+	lifetime := time.Duration(config.DBConnMaxLifetime) * time.Second
+	if lifetime == 0 {
+		lifetime = DefaultDBConnMaxLifetime
+	}
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxIdle)
+	db.SetConnMaxLifetime(lifetime)
+
+	// 設定の誤りは起動時に気付けるようにする
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// NewStore はdbを使うStoreを生成して返す
+// 検索はMySQLならFULLTEXTインデックス, それ以外は空のメモリ上の索引を設定する
+// リポジトリと検索はテストなどで生成後に差し替えてよい
+func NewStore(db *DB) *Store {
+	st := &Store{db: db, Hub: NewHub()}
+	st.Users = &SQLUserRepository{st: st}
+	st.Posts = &SQLPostRepository{st: st}
+	st.Followers = &SQLFollowerRepository{st: st}
+	if db.dialect.Name() == "mysql" {
+		st.Search = NewMySQLSearchBackend(db)
+	} else {
+		st.Search = NewMemorySearchBackend(db)
+	}
+	return st
+}
+
+// Close はDBへの接続を全て閉じる
+func (st *Store) Close() error {
+	return st.db.Close()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// SQLiteのDBファイルへ全てのマイグレーションを適用したStoreを返す
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	config := defaultConfig()
+	config.DBDriver = "sqlite3"
+	config.DBName = filepath.Join(t.TempDir(), "test.db")
+	db, err := openDB(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateUp(db); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	st := NewStore(db)
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+// nameという名前のユーザを登録して返す
+func newTestUser(t *testing.T, st *Store, name string) *User {
+	t.Helper()
+	u := &User{Name: name, Email: name + "@example.com", Password: "password"}
+	if err := st.Users.Entry(u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// userIDのsweetを投稿して返す
+func newTestPost(t *testing.T, st *Store, userID int64, message string) *Post {
+	t.Helper()
+	p := &Post{UserID: userID, Message: message}
+	if err := st.Posts.Entry(p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// userIDがfolloweeIDをフォローする
+func newTestFollow(t *testing.T, st *Store, userID int64, followeeID int64) {
+	t.Helper()
+	if err := st.Followers.Entry(&Follow{FollowerID: userID, FolloweeID: followeeID}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewStoreSearchBackend(t *testing.T) {
+	st := newTestStore(t)
+	if _, ok := st.Search.(*MemorySearchBackend); ok == false {
+		t.Errorf("NewStore on sqlite3 uses %T, want *MemorySearchBackend", st.Search)
+	}
+}
+
+func TestSQLUserRepository(t *testing.T) {
+	st := newTestStore(t)
+	alice := newTestUser(t, st, "alice")
+
+	var u User
+	exist, err := st.Users.FindByEmail(&u, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exist == false || u.ID != alice.ID || u.Name != "alice" {
+		t.Errorf("FindByEmail = %v, %+v", exist, u)
+	}
+	if exist, err := st.Users.Exists(alice.ID + 1); err != nil || exist == true {
+		t.Errorf("Exists(unknown) = %v, %v", exist, err)
+	}
+	ids, err := st.Users.FindIDsByName("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != alice.ID {
+		t.Errorf("FindIDsByName = %v", ids)
+	}
+}
+
+func TestSQLPostRepository(t *testing.T) {
+	st := newTestStore(t)
+	alice := newTestUser(t, st, "alice")
+	bob := newTestUser(t, st, "bob")
+	newTestFollow(t, st, alice.ID, bob.ID)
+
+	// 投稿はStoreのHubへ配信する
+	sub := st.Hub.Subscribe()
+	defer sub.Unsubscribe()
+	p := newTestPost(t, st, bob.ID, "@alice こんにちは #挨拶")
+	if e := <-sub.C; e.Type != HubEventSweet || e.PostID != p.ID {
+		t.Errorf("published %+v, want sweet %d", e, p.ID)
+	}
+
+	var found Post
+	exist, err := st.Posts.FindByID(&found, p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exist == false || found.UserName != "bob" || found.Mentions["alice"] != alice.ID {
+		t.Errorf("FindByID = %v, %+v", exist, found)
+	}
+
+	// フォローしているユーザのsweetがタイムラインに表示される
+	posts, _, _, err := st.Posts.TimelinePage(alice.ID, 10, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].ID != p.ID {
+		t.Errorf("TimelinePage = %v, want [%d]", postIDs(posts), p.ID)
+	}
+
+	// 削除したsweetは表示しない
+	if removed, err := st.Posts.Remove(&found, bob.ID); err != nil || removed == false {
+		t.Fatalf("Remove = %v, %v", removed, err)
+	}
+	posts, _, _, err = st.Posts.TimelinePage(alice.ID, 10, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 0 {
+		t.Errorf("TimelinePage after Remove = %v", postIDs(posts))
+	}
+}
+
+func TestSQLFollowerRepository(t *testing.T) {
+	st := newTestStore(t)
+	alice := newTestUser(t, st, "alice")
+	bob := newTestUser(t, st, "bob")
+
+	// 重ねてフォローしても1件
+	newTestFollow(t, st, alice.ID, bob.ID)
+	newTestFollow(t, st, alice.ID, bob.ID)
+	if following, err := st.Followers.IsFollowing(alice.ID, bob.ID); err != nil || following == false {
+		t.Errorf("IsFollowing = %v, %v", following, err)
+	}
+	follows, followers, err := st.Followers.Count(bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if follows != 0 || followers != 1 {
+		t.Errorf("Count(bob) = %d, %d, want 0, 1", follows, followers)
+	}
+
+	if err := st.Followers.Remove(&Follow{FollowerID: alice.ID, FolloweeID: bob.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if following, err := st.Followers.IsFollowing(alice.ID, bob.ID); err != nil || following == true {
+		t.Errorf("IsFollowing after Remove = %v, %v", following, err)
+	}
+}
+
+func TestSQLFollowerRepositoryPage(t *testing.T) {
+	st := newTestStore(t)
+	alice := newTestUser(t, st, "alice")
+	// 新しくフォローした順
+	var want []int64
+	for _, name := range []string{"bob", "carol", "dave", "erin", "frank"} {
+		u := newTestUser(t, st, name)
+		newTestFollow(t, st, u.ID, alice.ID)
+		want = append([]int64{u.ID}, want...)
+	}
+	userIDs := func(users []FollowUser) []int64 {
+		ids := make([]int64, 0, len(users))
+		for _, u := range users {
+			ids = append(ids, u.ID)
+		}
+		return ids
+	}
+
+	// 古い側へたどる
+	var got []int64
+	var before, last *TimelineCursor
+	for page := 0; ; page++ {
+		if page > len(want) {
+			t.Fatal("too many pages")
+		}
+		users, older, newer, err := st.Followers.Page(alice.ID, true, alice.ID, 2, before, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, userIDs(users)...)
+		last = newer
+		if older == nil {
+			break
+		}
+		before = older
+	}
+	if reflect.DeepEqual(got, want) == false {
+		t.Errorf("older pages = %v, want %v", got, want)
+	}
+
+	// 最後のページから新しい側へ戻る
+	got = nil
+	for page, after := 0, last; after != nil; page++ {
+		if page > len(want) {
+			t.Fatal("too many pages")
+		}
+		users, _, newer, err := st.Followers.Page(alice.ID, true, alice.ID, 2, nil, after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(userIDs(users), got...)
+		after = newer
+	}
+	if reflect.DeepEqual(got, want[:4]) == false {
+		t.Errorf("newer pages = %v, want %v", got, want[:4])
+	}
+}
+
+// postsのIDを並び順に返す
+func postIDs(posts []Post) []int64 {
+	ids := make([]int64, 0, len(posts))
+	for _, p := range posts {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
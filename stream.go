@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// StreamHeartbeatInterval はイベントがない間も接続を保つためにコメントを送る間隔
+	StreamHeartbeatInterval = 30 * time.Second
+	// StreamReplayLimit は再接続時にLast-Event-ID以降のsweetを送り直す最大件数
+	// これを超える場合はタイムラインの再読み込みを促す
+	StreamReplayLimit = 100
+	// StreamRetryMillis はクライアントが再接続するまでの待ち時間(ミリ秒)
+	StreamRetryMillis = 3000
+)
+
+// Server-Sent Eventsのイベントを1つ書き出す
+// dataはJSONにして送る
+func writeStreamEvent(w io.Writer, id int64, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, b)
+	return err
+}
+
+// 再接続時に送られるLast-Event-IDを取得する
+// ヘッダを送れないクライアントのためにlast_event_idパラメータも認める. なければ0を返す
+func parseLastEventID(r *http.Request) (int64, error) {
+	str := r.Header.Get("Last-Event-ID")
+	if str == "" {
+		str = r.URL.Query().Get("last_event_id")
+	}
+	if str == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
+
+// [/timeline/stream]のハンドラ
+// タイムラインに新しく表示されるsweetをServer-Sent Eventsで送る
+// イベントのIDはsweetのIDで, 再接続時はLast-Event-ID以降のsweetから送り直す
+func (app *App) timelineStreamHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// GET以外は存在しない
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if ok == false {
+		http.Error(w, "Streaming unsupported.", http.StatusInternalServerError)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	lastID, err := parseLastEventID(r)
+	if err != nil {
+		http.Error(w, "Bad Request.", http.StatusBadRequest)
+		return
+	}
+
+	// 接続を保ち続けるのでサーバのタイムアウトを外す
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		log.Println(err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Println(err)
+	}
+
+	// 送り直しの間に投稿されたものを取りこぼさないよう先に購読する
+	sub := app.Hub.Subscribe()
+	defer sub.Unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", StreamRetryMillis)
+
+	// 切断中のsweetを送り直す
+	if lastID > 0 {
+		posts, err := app.Posts.SweetsSinceID(uid, lastID, StreamReplayLimit+1)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if len(posts) > StreamReplayLimit {
+			// 多すぎる場合はタイムラインを再読み込みしてもらう
+			if err := writeStreamEvent(w, lastID, "reload", struct{}{}); err != nil {
+				return
+			}
+			posts = nil
+		}
+		for _, p := range posts {
+			if err := writeStreamEvent(w, p.ID, HubEventSweet, newAPISweet(p)); err != nil {
+				return
+			}
+			lastID = p.ID
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(StreamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e, ok := <-sub.C:
+			// 配信が追いつかずに打ち切られた場合やサーバの停止時はクライアントの再接続に任せる
+			if ok == false {
+				return
+			}
+			if e.Type != HubEventSweet || e.PostID <= lastID {
+				continue
+			}
+			// タイムラインに表示されるものだけ送る
+			p, exist, err := app.Posts.TimelineSweet(uid, e.PostID)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			if exist == false {
+				continue
+			}
+			if err := writeStreamEvent(w, p.ID, HubEventSweet, newAPISweet(p)); err != nil {
+				return
+			}
+			lastID = p.ID
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ThreadMaxAncestors はスレッド表示で遡る返信先の最大数
+	ThreadMaxAncestors = 100
+	// ThreadMaxReplies はスレッド表示で取得する返信の最大数
+	ThreadMaxReplies = 500
+)
+
+// Thread はsweetを中心とした会話のスレッド
+type Thread struct {
+	Ancestors []Post // 返信先を古い順に並べたもの
+	Post      Post
+	Replies   []Post // 返信を会話の順に並べたもの. Depthに深さが入る
+}
+
+// ThreadForTemplate はスレッド画面用のデータ構造
+type ThreadForTemplate struct {
+	Messages  []string
+	Resweeted bool // 閲覧者がリスイートしていればtrue
+	Deletable bool // 閲覧者が削除できればtrue
+	Editable  bool // 閲覧者が編集できればtrue
+	Revisions []PostRevision
+	Thread
+}
+
+// findThread はidのsweetのスレッドを取得する
+// sweetが存在しなければfalseを返す
+func (st *Store) findThread(id int64) (*Thread, bool, error) {
+	t := &Thread{}
+	exist, err := st.Posts.FindByID(&t.Post, id)
+	if err != nil {
+		return nil, false, err
+	}
+	if exist == false {
+		return nil, false, nil
+	}
+
+	// 返信先を遡る
+	ancestors := make([]Post, 0)
+	parentID := t.Post.InReplyTo
+	for parentID != 0 && len(ancestors) < ThreadMaxAncestors {
+		var parent Post
+		exist, err := st.Posts.FindByID(&parent, parentID)
+		if err != nil {
+			return nil, false, err
+		}
+		if exist == false {
+			break
+		}
+		ancestors = append(ancestors, parent)
+		parentID = parent.InReplyTo
+	}
+	reverseSlice(ancestors)
+	t.Ancestors = ancestors
+
+	// 返信を辿る
+	t.Replies, err = st.findReplies(id)
+	if err != nil {
+		return nil, false, err
+	}
+	return t, true, nil
+}
+
+// fillLikedByMe はスレッド内のsweetのLikedByMeをuserIDについて設定する
+func (t *Thread) fillLikedByMe(st *Store, userID int64) error {
+	posts := []Post{t.Post}
+	if err := st.fillLikedByMe(userID, posts); err != nil {
+		return err
+	}
+	t.Post = posts[0]
+	if err := st.fillLikedByMe(userID, t.Ancestors); err != nil {
+		return err
+	}
+	return st.fillLikedByMe(userID, t.Replies)
+}
+
+// hideInvisible はスレッドからuserIDに表示出来ないsweetを除く
+// 中心のsweetが対象であればfalseを返す
+func (t *Thread) hideInvisible(st *Store, userID int64) (bool, error) {
+	posts, err := st.hideInvisible(userID, []Post{t.Post})
+	if err != nil {
+		return false, err
+	}
+	if len(posts) == 0 {
+		return false, nil
+	}
+	t.Ancestors, err = st.hideInvisible(userID, t.Ancestors)
+	if err != nil {
+		return false, err
+	}
+	// 除いた返信への返信も除く
+	replies, err := st.hideInvisible(userID, t.Replies)
+	if err != nil {
+		return false, err
+	}
+	hidden := make(map[int64]bool)
+	visible := make([]Post, 0, len(replies))
+	kept := make(map[int64]bool)
+	for _, p := range replies {
+		kept[p.ID] = true
+	}
+	for _, p := range t.Replies {
+		if kept[p.ID] == false || hidden[p.InReplyTo] == true {
+			hidden[p.ID] = true
+			continue
+		}
+		visible = append(visible, p)
+	}
+	t.Replies = visible
+	return true, nil
+}
+
+// rootIDへの返信を深さ優先の順に並べて返す
+// 同じ返信先への返信は古い順に並べる
+func (st *Store) findReplies(rootID int64) ([]Post, error) {
+	// 1階層ずつ返信を取得する
+	children := make(map[int64][]Post)
+	count := 0
+	parents := []int64{rootID}
+	for len(parents) > 0 && count < ThreadMaxReplies {
+		posts, err := st.findPostsInReplyTo(parents, ThreadMaxReplies-count)
+		if err != nil {
+			return nil, err
+		}
+		parents = parents[:0]
+		for _, p := range posts {
+			children[p.InReplyTo] = append(children[p.InReplyTo], p)
+			parents = append(parents, p.ID)
+		}
+		count += len(posts)
+	}
+
+	// 深さ優先で並べる
+	replies := make([]Post, 0, count)
+	var walk func(id int64, depth int)
+	walk = func(id int64, depth int) {
+		for _, p := range children[id] {
+			p.Depth = depth
+			replies = append(replies, p)
+			walk(p.ID, depth+1)
+		}
+	}
+	walk(rootID, 1)
+	return replies, nil
+}
+
+// idsのいずれかへの返信を古い順に最大limit件取得する
+func (st *Store) findPostsInReplyTo(ids []int64, limit int) ([]Post, error) {
+	db := st.db
+	// パラメータ組み立て
+	placeholders := make([]string, 0, len(ids))
+	args := make([]interface{}, 0, len(ids)+1)
+	for _, id := range ids {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+	args = append(args, limit)
+
+	// SQL発行
+	rows, err := db.Query(`
+		SELECT`+postSelectColumns(db.dialect)+`
+		FROM`+postFromTables+`
+		WHERE
+			p.in_reply_to IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY
+			p.created_at ASC, p.id ASC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPosts(rows)
+}
+
+// [/sweets/{id}]以下のパスを分解する
+// /sweets/12 なら12と空文字列, /sweets/12/edit なら12と"edit"を返す
+func parseSweetPath(path string) (int64, string, bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/sweets/"), "/"), "/")
+	if len(parts) == 0 || len(parts) > 2 {
+		return 0, "", false
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	if len(parts) == 1 {
+		return id, "", true
+	}
+	return id, parts[1], true
+}
+
+// userIDの閲覧するスレッド画面を表示する
+func (app *App) renderThread(w http.ResponseWriter, t *Thread, userID int64, messages []string) {
+	resweeted, err := app.Posts.IsResweeted(userID, t.Post.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if err := t.fillLikedByMe(app.Store, userID); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	tft := &ThreadForTemplate{Messages: messages, Resweeted: resweeted, Thread: *t}
+	// 投稿者本人のみ削除, 編集できる
+	if t.Post.UserID == userID && t.Post.Deleted == false {
+		tft.Deletable = true
+		tft.Editable = t.Post.IsEditable(time.Now())
+	}
+	// 編集履歴の取得
+	if t.Post.IsEdited() == true && t.Post.Deleted == false {
+		tft.Revisions, err = app.findPostRevisions(t.Post.ID)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Sorry.", http.StatusInternalServerError)
+			return
+		}
+	}
+	err = responseTemplate.ExecuteTemplate(w, "thread.tmpl", tft)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+	}
+}
+
+// [/sweets/]以下のハンドラ
+func (app *App) sweetHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	id, sub, ok := parseSweetPath(r.URL.Path)
+	if ok == false {
+		http.NotFound(w, r)
+		return
+	}
+	switch sub {
+	case "":
+		app.threadHandler(w, r, s, id)
+	case "edit":
+		app.editSweetHandler(w, r, s, id)
+	case "delete":
+		app.deleteSweetHandler(w, r, s, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// [/sweets/{id}]のハンドラ
+func (app *App) threadHandler(w http.ResponseWriter, r *http.Request, s *Session, id int64) {
+	// GET以外は存在しない
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	// スレッドの取得
+	t, exist, err := app.findThread(id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if exist == false {
+		http.NotFound(w, r)
+		return
+	}
+	// リスイートはリスイート元のスレッドを表示
+	if t.Post.IsResweet() == true {
+		http.Redirect(w, r, threadPath(t.Post.ResweetOf), http.StatusFound)
+		return
+	}
+	// ブロック関係にあるユーザや鍵アカウントのsweetは表示しない
+	visible, err := t.hideInvisible(app.Store, uid)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if visible == false {
+		http.NotFound(w, r)
+		return
+	}
+	app.renderThread(w, t, uid, nil)
+}
+
+// [/sweets/{id}/edit]のハンドラ
+func (app *App) editSweetHandler(w http.ResponseWriter, r *http.Request, s *Session, id int64) {
+	// POST以外は存在しない
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	// スレッドの取得
+	t, exist, err := app.findThread(id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if exist == false {
+		http.NotFound(w, r)
+		return
+	}
+	// 入力チェック
+	message := r.FormValue("message")
+	if err := t.Post.ValidateEdit(uid, message); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if len(t.Post.Messages) > 0 {
+		// 入力エラーがあればスレッドを再表示
+		app.renderThread(w, t, uid, t.Post.Messages)
+		return
+	}
+	// 編集
+	if err := app.Posts.Edit(&t.Post, message); err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, threadPath(id), http.StatusFound)
+}
+
+// [/sweets/{id}/delete]のハンドラ
+func (app *App) deleteSweetHandler(w http.ResponseWriter, r *http.Request, s *Session, id int64) {
+	// POST以外は存在しない
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	// 削除
+	// 他のユーザのsweetや削除済のsweetは存在しないものとして扱う
+	p := &Post{ID: id}
+	removed, err := app.Posts.Remove(p, uid)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Sorry.", http.StatusInternalServerError)
+		return
+	}
+	if removed == false {
+		http.NotFound(w, r)
+		return
+	}
+	// 指定がなければタイムラインへ回す
+	redirectBack(w, r, "/timeline")
+}
+
+// スレッド画面のパス
+func threadPath(id int64) string {
+	return fmt.Sprintf("/sweets/%d", id)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// SessionTokenScopeKey はトークン認証時のSession内におけるスコープのキー
+	// Cookieによるセッションでは設定されない
+	SessionTokenScopeKey = "TokenScope"
+	// TokenScopeRead は参照のみ許可するスコープ
+	TokenScopeRead = "read"
+	// TokenScopePost は投稿などの更新も許可するスコープ
+	TokenScopePost = "post"
+	// AccessTokenPrefix は発行するトークンの接頭辞
+	AccessTokenPrefix = "sut_"
+	// AccessTokenLength はトークンの接頭辞を除いた長さ
+	AccessTokenLength = 40
+)
+
+// AccessToken はAPI利用のための個人用アクセストークン
+type AccessToken struct {
+	ID        int64
+	UserID    int64
+	Name      string
+	Scope     string
+	Token     string // 発行直後のみ設定される平文のトークン
+	CreatedAt time.Time
+	Messages  []string // エラーメッセージ
+}
+
+// AccessTokensForTemplate はトークン管理画面用のデータ構造
+type AccessTokensForTemplate struct {
+	Messages []string
+	Tokens   []AccessToken
+	Created  *AccessToken
+}
+
+// トークンをDB保存用にハッシュ化
+// 十分な長さの乱数なのでソルトやストレッチングは行わない
+func accessTokenHashing(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// 新しいトークン文字列を生成
+func createAccessToken() (string, error) {
+	buf := make([]byte, AccessTokenLength/2)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%x", AccessTokenPrefix, buf), nil
+}
+
+// Validate はDB登録前のバリデーションチェック
+func (t *AccessToken) Validate() error {
+	var messages []string
+
+	// 名前の文字数チェック
+	if n := utf8.RuneCountInString(t.Name); n < 1 || 50 < n {
+		messages = append(messages, "トークン名は1文字以上, 50文字以内で入力してください")
+	}
+
+	// スコープチェック
+	if t.Scope != TokenScopeRead && t.Scope != TokenScopePost {
+		messages = append(messages, "スコープが不正です")
+	}
+
+	// エラーメッセージを登録しておく
+	t.Messages = messages
+
+	return nil
+}
+
+// Entry はトークンを発行してハッシュをDBへ登録するメソッド
+// 平文のトークンはTokenへ設定されるが保存はされない
+func (t *AccessToken) Entry(st *Store) error {
+	db := st.db
+
+	// トークン生成
+	token, err := createAccessToken()
+	if err != nil {
+		return err
+	}
+
+	// クエリ発行
+	t.CreatedAt = time.Now()
+	insertID, err := db.Insert("INSERT INTO access_tokens(user_id, name, token_hash, scope, created_at) VALUES(?, ?, ?, ?, ?)", t.UserID, t.Name, accessTokenHashing(token), t.Scope, t.CreatedAt)
+	if err != nil {
+		return err
+	}
+	// 登録したIDを構造体へ入れてやる
+	t.ID = insertID
+	t.Token = token
+
+	return nil
+}
+
+// Remove はトークンを失効させる
+// 他のユーザのトークンは削除しない
+func (t *AccessToken) Remove(st *Store) error {
+	db := st.db
+
+	// プリペアードステートメント生成
+	stmt, err := db.Prepare(`
+		DELETE
+		FROM
+			access_tokens
+		WHERE
+			id = ?
+		AND
+			user_id = ?
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// クエリ発行
+	_, err = stmt.Exec(t.ID, t.UserID)
+	return err
+}
+
+// tokenに一致するトークンを探して, tの内容を置き換える
+func (t *AccessToken) findByToken(st *Store, token string) (bool, error) {
+	db := st.db
+
+	// クエリ発行
+	err := db.QueryRow(`
+	SELECT
+		t.id,
+		t.user_id,
+		t.name,
+		t.scope,
+		t.created_at
+	FROM
+		access_tokens t
+	WHERE
+		t.token_hash = ?
+	`, accessTokenHashing(token)).Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.CreatedAt)
+
+	// 存在判定
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+// userIDが発行したトークン一覧を返す
+func (st *Store) findAccessTokensByUserID(userID int64) ([]AccessToken, error) {
+	db := st.db
+	// SQL発行
+	rows, err := db.Query(`
+		SELECT
+			t.id,
+			t.user_id,
+			t.name,
+			t.scope,
+			t.created_at
+		FROM
+			access_tokens t
+		WHERE
+			t.user_id = ?
+		ORDER BY
+			t.created_at desc
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]AccessToken, 0)
+	for rows.Next() {
+		var t AccessToken
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
+
+// AuthorizationヘッダからBearerトークンを取り出す
+// ヘッダがなければfalseを返す
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if h == "" {
+		return "", false
+	}
+	parts := strings.SplitN(h, " ", 2)
+	if len(parts) != 2 || strings.EqualFold(parts[0], "Bearer") == false {
+		return "", false
+	}
+	return strings.TrimSpace(parts[1]), true
+}
+
+// Bearerトークンで認証できればtrueとトークン用のセッションを返す.
+// トークン用のセッションは保存されず, リクエストの間だけ有効.
+func (st *Store) isTokenStarted(r *http.Request) (bool, *Session, error) {
+	token, ok := bearerToken(r)
+	if ok == false || token == "" {
+		return false, nil, nil
+	}
+	t := &AccessToken{}
+	exist, err := t.findByToken(st, token)
+	if err != nil {
+		return false, nil, err
+	}
+	if exist == false {
+		return false, nil, nil
+	}
+	s := &Session{
+		expireTime: time.Now(),
+		data: map[interface{}]interface{}{
+			SessionUserIDKey:     t.UserID,
+			SessionTokenScopeKey: t.Scope,
+		},
+	}
+	return true, s, nil
+}
+
+// isTokenSession はsがトークン認証によるセッションであればtrueを返す
+func isTokenSession(s *Session) bool {
+	v, _ := s.Get(SessionTokenScopeKey)
+	return v != nil
+}
+
+// tokenScopeAllows はsのトークンスコープでrを処理してよければtrueを返す
+// Cookieによるセッションは常にtrue
+func tokenScopeAllows(s *Session, r *http.Request) bool {
+	v, _ := s.Get(SessionTokenScopeKey)
+	switch v {
+	case nil, TokenScopePost:
+		return true
+	case TokenScopeRead:
+		return r.Method == "GET" || r.Method == "HEAD"
+	default:
+		return false
+	}
+}
+
+// リクエストの認証を行う
+// Authorizationヘッダがあればトークンで, なければCookieのセッションで認証する
+func (st *Store) loginSession(w http.ResponseWriter, r *http.Request) (bool, *Session, error) {
+	if _, ok := bearerToken(r); ok == true {
+		return st.isTokenStarted(r)
+	}
+	return sessionManager.IsSessionStarted(w, r)
+}
@@ -2,9 +2,9 @@ package main
 
 import (
 	"crypto/rand"
-	"crypto/sha256"
 	"database/sql"
 	"fmt"
+	"log"
 	"regexp"
 	"time"
 	"unicode/utf8"
@@ -17,14 +17,17 @@ const (
 
 // User はDB登録と画面表示データの引き渡しに使うユーザ情報の構造体
 type User struct {
-	ID              int64    // 登録したID
-	Name            string   // 表示ユーザ名
-	Email           string   // 登録Emailアドレス(ID兼ねる)
-	Password        string   // パスワード
-	ConfirmPassword string   // 確認パスワード
-	Salt            string   // ハッシュ化に用いたソルト
-	HashedPassword  string   // ハッシュ化されたパスワード
-	Messages        []string // エラーメッセージ
+	ID                int64     // 登録したID
+	Name              string    // 表示ユーザ名
+	Email             string    // 登録Emailアドレス(ID兼ねる)
+	Password          string    // パスワード
+	ConfirmPassword   string    // 確認パスワード
+	Salt              string    // ハッシュ化に用いたソルト
+	HashedPassword    string    // ハッシュ化されたパスワード
+	PasswordAlgorithm string    // ハッシュ化に用いたアルゴリズム
+	Protected         bool      // 鍵アカウント. 承認したフォロワーにのみsweetを表示する
+	CreatedAt         time.Time // 登録日時
+	Messages          []string  // エラーメッセージ
 }
 
 // n文字のソルトを生成
@@ -36,10 +39,22 @@ func createSalt(n int) (string, error) {
 	return fmt.Sprintf("%x", buf), nil
 }
 
-// パスワードをハッシュ化
-func passwordHashing(password string, salt string) string {
-	str := password + salt
-	return fmt.Sprintf("%x", sha256.Sum256([]byte(str)))
+// パスワードをDefaultPasswordAlgorithmでハッシュ化
+// 新しいソルト, ハッシュ, アルゴリズムを返す
+func passwordHashing(password string) (string, string, string, error) {
+	salt, err := createSalt(SaltLength)
+	if err != nil {
+		return "", "", "", err
+	}
+	h, err := passwordHasher(DefaultPasswordAlgorithm)
+	if err != nil {
+		return "", "", "", err
+	}
+	hashed, err := h.Hash(password, salt)
+	if err != nil {
+		return "", "", "", err
+	}
+	return salt, hashed, DefaultPasswordAlgorithm, nil
 }
 
 // メールアドレスチェック
@@ -49,17 +64,18 @@ func validateEmailFormat(email string) bool {
 	return re.MatchString(email)
 }
 
-// UserIDが存在する場合はtrue
-func isExistUserID(id int64) (bool, error) {
-	// コネクション取得
-	db, err := DBConnection()
-	if err != nil {
-		return false, err
-	}
+// SQLUserRepository はusersテーブルへ保存するUserRepository
+type SQLUserRepository struct {
+	st *Store
+}
+
+// Exists はidのユーザが存在する場合はtrue
+func (ur *SQLUserRepository) Exists(id int64) (bool, error) {
+	db := ur.st.db
 
 	// クエリ発行
 	var dbID int64
-	err = db.QueryRow(`
+	err := db.QueryRow(`
 	SELECT
 		u.id
 	FROM
@@ -79,17 +95,13 @@ func isExistUserID(id int64) (bool, error) {
 	}
 }
 
-// emailが存在する場合はtrue
-func isExistEmail(email string) (bool, error) {
-	// コネクション取得
-	db, err := DBConnection()
-	if err != nil {
-		return false, err
-	}
+// EmailExists はemailが存在する場合はtrue
+func (ur *SQLUserRepository) EmailExists(email string) (bool, error) {
+	db := ur.st.db
 
 	// クエリ発行
 	var dbEmail string
-	err = db.QueryRow(`
+	err := db.QueryRow(`
 	SELECT
 		u.email
 	FROM
@@ -109,13 +121,9 @@ func isExistEmail(email string) (bool, error) {
 	}
 }
 
-// emailでユーザを探して, uの内容を置き換える
-func (u *User) findByEmail(email string) (bool, error) {
-	// コネクション取得
-	db, err := DBConnection()
-	if err != nil {
-		return false, err
-	}
+// FindByEmail はemailでユーザを探して, uの内容を置き換える
+func (ur *SQLUserRepository) FindByEmail(u *User, email string) (bool, error) {
+	db := ur.st.db
 
 	// クエリ発行
 	var dbID int64
@@ -123,18 +131,20 @@ func (u *User) findByEmail(email string) (bool, error) {
 	var dbEmail string
 	var dbSalt string
 	var dbHashedPassword string
-	err = db.QueryRow(`
+	var dbPasswordAlgorithm string
+	err := db.QueryRow(`
 	SELECT
 		u.id,
 		u.name,
 		u.email,
 		u.salt,
-		u.hashed_password
+		u.hashed_password,
+		u.password_algorithm
 	FROM
 		users u
 	WHERE
 		u.email = ?
-	`, email).Scan(&dbID, &dbName, &dbEmail, &dbSalt, &dbHashedPassword)
+	`, email).Scan(&dbID, &dbName, &dbEmail, &dbSalt, &dbHashedPassword, &dbPasswordAlgorithm)
 
 	// 存在判定
 	switch {
@@ -148,12 +158,54 @@ func (u *User) findByEmail(email string) (bool, error) {
 		u.Name = dbName
 		u.Salt = dbSalt
 		u.HashedPassword = dbHashedPassword
+		u.PasswordAlgorithm = dbPasswordAlgorithm
+		return true, nil
+	}
+}
+
+// FindByID はidでユーザを探して, uの内容を置き換える
+func (ur *SQLUserRepository) FindByID(u *User, id int64) (bool, error) {
+	db := ur.st.db
+
+	// クエリ発行
+	var dbID int64
+	var dbName string
+	var dbEmail string
+	var dbProtected bool
+	var dbCreatedAt time.Time
+	err := db.QueryRow(`
+	SELECT
+		u.id,
+		u.name,
+		u.email,
+		u.protected,
+		u.created_at
+	FROM
+		users u
+	WHERE
+		u.id = ?
+	`, id).Scan(&dbID, &dbName, &dbEmail, &dbProtected, &dbCreatedAt)
+
+	// 存在判定
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		// 見つかったのでデータを設定
+		u.ID = dbID
+		u.Name = dbName
+		u.Email = dbEmail
+		u.Protected = dbProtected
+		u.CreatedAt = dbCreatedAt
 		return true, nil
 	}
 }
 
 // Validate はDB登録前のバリデーションチェック
-func (u *User) Validate() error {
+// メールアドレスの重複はusersで確認する
+func (u *User) Validate(users UserRepository) error {
 	var messages []string
 
 	// Name
@@ -171,7 +223,7 @@ func (u *User) Validate() error {
 		messages = append(messages, "メールアドレスが不正です")
 	} else {
 		// 登録済みチェック
-		exist, err := isExistEmail(u.Email)
+		exist, err := users.EmailExists(u.Email)
 		if err != nil {
 			return err
 		}
@@ -196,39 +248,21 @@ func (u *User) Validate() error {
 }
 
 // Entry はDBへユーザ情報を新規登録するメソッド
-func (u *User) Entry() error {
-	// コネクション取得
-	db, err := DBConnection()
-	if err != nil {
-		return err
-	}
-
-	// プリペアードステートメント生成
-	stmt, err := db.Prepare("INSERT INTO users(name, email, hashed_password, salt, created_at) VALUES(?, ?, ?, ?, ?)")
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
+func (ur *SQLUserRepository) Entry(u *User) error {
+	db := ur.st.db
 
-	// ソルト生成
-	salt, err := createSalt(SaltLength)
+	// パスワードハッシュ化
+	salt, hashedPass, algorithm, err := passwordHashing(u.Password)
 	if err != nil {
 		return err
 	}
 
-	// パスワードハッシュ化
-	hashedPass := passwordHashing(u.Password, salt)
-
 	// クエリ発行
-	result, err := stmt.Exec(u.Name, u.Email, hashedPass, salt, time.Now())
+	insertID, err := db.Insert("INSERT INTO users(name, email, hashed_password, salt, password_algorithm, created_at) VALUES(?, ?, ?, ?, ?, ?)", u.Name, u.Email, hashedPass, salt, algorithm, time.Now())
 	if err != nil {
 		return err
 	}
 	// 登録したIDを構造体へ入れてやる
-	insertID, err := result.LastInsertId()
-	if err != nil {
-		return err
-	}
 	u.ID = insertID
 
 	return nil
@@ -236,10 +270,11 @@ func (u *User) Entry() error {
 
 // Authenticate はu内の情報で認証を行う
 // 認証成功の場合, uの各フィールドへ値を設定する
-func (u *User) Authenticate() (bool, error) {
+// ユーザ情報はusersから取得する
+func (u *User) Authenticate(users UserRepository) (bool, error) {
 	findUser := &User{Email: u.Email}
 	// User情報をDBから取得
-	exist, err := findUser.findByEmail(findUser.Email)
+	exist, err := users.FindByEmail(findUser, findUser.Email)
 	if err != nil {
 		return false, err
 	}
@@ -247,15 +282,95 @@ func (u *User) Authenticate() (bool, error) {
 		u.Messages = append(u.Messages, "メールアドレスまたはパスワードが異なります")
 		return false, nil
 	}
-	// salt + passwordで計算
-	hashedPass := passwordHashing(u.Password, findUser.Salt)
-	// hashed_passwordと比較
-	if hashedPass != findUser.HashedPassword {
+	// 登録時のアルゴリズムでhashed_passwordと比較
+	h, err := passwordHasher(findUser.PasswordAlgorithm)
+	if err != nil {
+		return false, err
+	}
+	ok, err := h.Verify(u.Password, findUser.Salt, findUser.HashedPassword)
+	if err != nil {
+		return false, err
+	}
+	if ok == false {
 		u.Messages = append(u.Messages, "メールアドレスまたはパスワードが異なります")
 		return false, nil
 	}
+	// 古いアルゴリズムのままなら現在のアルゴリズムでハッシュし直す
+	// 失敗してもログインには影響させない
+	if findUser.PasswordAlgorithm != DefaultPasswordAlgorithm {
+		if err := users.UpdatePassword(findUser, u.Password); err != nil {
+			log.Println(err)
+		}
+	}
 	// 呼び出し元へ値をコピー
 	u.ID = findUser.ID
 	u.Name = findUser.Name
 	return true, nil
 }
+
+// UpdatePassword はpasswordをDefaultPasswordAlgorithmでハッシュし直してDBを更新する
+func (ur *SQLUserRepository) UpdatePassword(u *User, password string) error {
+	// パスワードハッシュ化
+	salt, hashedPass, algorithm, err := passwordHashing(password)
+	if err != nil {
+		return err
+	}
+
+	db := ur.st.db
+
+	// プリペアードステートメント生成
+	stmt, err := db.Prepare(`
+		UPDATE
+			users
+		SET
+			hashed_password = ?,
+			salt = ?,
+			password_algorithm = ?
+		WHERE
+			id = ?
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// クエリ発行
+	if _, err := stmt.Exec(hashedPass, salt, algorithm, u.ID); err != nil {
+		return err
+	}
+	u.Salt = salt
+	u.HashedPassword = hashedPass
+	u.PasswordAlgorithm = algorithm
+	return nil
+}
+
+// FindIDsByName はnameという名前のユーザのID一覧を返す
+// ユーザ名は重複し得るので複数返すことがある
+func (ur *SQLUserRepository) FindIDsByName(name string) ([]int64, error) {
+	db := ur.st.db
+	// SQL発行
+	rows, err := db.Query(`
+		SELECT
+			u.id
+		FROM
+			users u
+		WHERE
+			u.name = ?
+		ORDER BY
+			u.id
+	`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -1,44 +1,293 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
-	"fmt"
-	_ "github.com/go-sql-driver/mysql"
+	"errors"
+	"flag"
 	"io/ioutil"
-	"path/filepath"
+	"os"
+	"strings"
 )
 
-// アプリケーション設定
-var applicationConfig *Config
+const (
+	// DefaultConfigPath は設定ファイルの既定のパス
+	DefaultConfigPath = "./config.json"
+	// ConfigEnvPrefix は設定を上書きする環境変数の接頭辞
+	// フラグ名を大文字にして-を_にしたものを続ける(db-userならSUITTER_DB_USER)
+	ConfigEnvPrefix = "SUITTER_"
+)
 
 // Config はプログラムの起動時設定を格納する
+// 既定値, 設定ファイル, 環境変数, コマンドラインフラグの順に読み込み, 後のものほど優先する
 type Config struct {
+	// DBDriver は接続するDB(mysql, sqlite3, postgres)
+	DBDriver string
+	// DBHost はDBサーバのホスト. 空ならドライバの既定(ローカル)へ接続する
+	DBHost string
+	// DBPort はDBサーバのポート. 0ならドライバの既定
+	DBPort int
+	// DBName はDB名. DBDriverがsqlite3の場合はDBファイルのパス
+	DBName     string
 	DBUser     string
 	DBPassword string
-	DBName     string
+	// DBTLS はDBサーバとの接続の暗号化(disable, require, verify). 空ならドライバの既定
+	// requireは証明書を検証せずに暗号化し, verifyは証明書も検証する
+	DBTLS string
+	// DBMaxOpenConns はDBへの最大接続数. 0ならDefaultDBMaxOpenConns
+	DBMaxOpenConns int
+	// DBMaxIdleConns は待機させておく接続の最大数. 0ならDefaultDBMaxIdleConns
+	DBMaxIdleConns int
+	// DBConnMaxLifetime は1つの接続を使い続ける最大の秒数. 0ならDefaultDBConnMaxLifetime
+	DBConnMaxLifetime int
+	// AutoMigrate がtrueなら起動時に未適用のマイグレーションを適用する
+	AutoMigrate bool
+	// SessionStore はセッションの保存先(memory, db, file). mysqlはdbと同じ
+	SessionStore string
+	// SessionDir はSessionStoreがfileの場合の保存先ディレクトリ
+	SessionDir string
+	// SessionCookieName はセッションIDを保存するクッキーの名前
+	SessionCookieName string
+	// SessionMaxAge はセッションの有効期間の秒数
+	SessionMaxAge int
+	// SearchBackend はsweet検索の方式(mysql, memory). 空ならDBDriverに合わせる
+	SearchBackend string
+	// Listen は待ち受けるアドレス
+	Listen string
+	// ReadTimeout はヘッダと本文を含めてリクエストを読み込む最大の秒数
+	ReadTimeout int
+	// WriteTimeout はリクエストを読み込んでからレスポンスを書き終えるまでの最大の秒数
+	// タイムラインのストリームとWebSocketには適用しない
+	WriteTimeout int
+	// IdleTimeout はkeep-aliveで次のリクエストを待つ最大の秒数
+	IdleTimeout int
+	// MaxHeaderBytes はリクエストヘッダの最大バイト数
+	MaxHeaderBytes int
+	// ShutdownTimeout は停止時に処理中のリクエストの完了を待つ最大の秒数
+	ShutdownTimeout int
+	// TimelinePageLimit は1ページあたりのsweetの表示件数
+	TimelinePageLimit int
+	// UserSearchPageLimit は1ページあたりのユーザ表示件数
+	UserSearchPageLimit int
+	// TemplateDir はテンプレートファイルのディレクトリ
+	TemplateDir string
+	// StaticDir は静的ファイルのディレクトリ
+	StaticDir string
+}
+
+// 既定値の設定を返す
+func defaultConfig() *Config {
+	return &Config{
+		DBDriver:            "mysql",
+		SessionStore:        "memory",
+		SessionDir:          "./sessions",
+		SessionCookieName:   "suitter",
+		SessionMaxAge:       86400,
+		Listen:              ":80",
+		ReadTimeout:         10,
+		WriteTimeout:        30,
+		IdleTimeout:         120,
+		MaxHeaderBytes:      1 << 16,
+		ShutdownTimeout:     30,
+		TimelinePageLimit:   50,
+		UserSearchPageLimit: 50,
+		TemplateDir:         "./templates",
+		StaticDir:           "./static",
+	}
 }
 
-// 設定ファイルの読み出し
-func loadConfig(path string) (*Config, error) {
-	p := filepath.Join(path, "config.json")
+// cの各項目をfsのフラグへ結び付ける
+// フラグの既定値はその時点のcの値になる
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.DBDriver, "db-driver", c.DBDriver, "DB driver (mysql, sqlite3, postgres)")
+	fs.StringVar(&c.DBHost, "db-host", c.DBHost, "DB server host")
+	fs.IntVar(&c.DBPort, "db-port", c.DBPort, "DB server port (0 for driver default)")
+	fs.StringVar(&c.DBName, "db-name", c.DBName, "DB name, or file path for sqlite3")
+	fs.StringVar(&c.DBUser, "db-user", c.DBUser, "DB user")
+	fs.StringVar(&c.DBPassword, "db-password", c.DBPassword, "DB password")
+	fs.StringVar(&c.DBTLS, "db-tls", c.DBTLS, "DB connection TLS (disable, require, verify)")
+	fs.IntVar(&c.DBMaxOpenConns, "db-max-open-conns", c.DBMaxOpenConns, "max open DB connections")
+	fs.IntVar(&c.DBMaxIdleConns, "db-max-idle-conns", c.DBMaxIdleConns, "max idle DB connections")
+	fs.IntVar(&c.DBConnMaxLifetime, "db-conn-max-lifetime", c.DBConnMaxLifetime, "max lifetime of a DB connection in seconds")
+	fs.BoolVar(&c.AutoMigrate, "auto-migrate", c.AutoMigrate, "apply pending migrations on boot")
+	fs.StringVar(&c.SessionStore, "session-store", c.SessionStore, "session store (memory, db, file)")
+	fs.StringVar(&c.SessionDir, "session-dir", c.SessionDir, "session directory for the file session store")
+	fs.StringVar(&c.SessionCookieName, "session-cookie-name", c.SessionCookieName, "session cookie name")
+	fs.IntVar(&c.SessionMaxAge, "session-max-age", c.SessionMaxAge, "session max age in seconds")
+	fs.StringVar(&c.SearchBackend, "search-backend", c.SearchBackend, "sweet search backend (mysql, memory)")
+	fs.StringVar(&c.Listen, "listen", c.Listen, "listen address")
+	fs.IntVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "request read timeout in seconds")
+	fs.IntVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "response write timeout in seconds")
+	fs.IntVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "keep-alive idle timeout in seconds")
+	fs.IntVar(&c.MaxHeaderBytes, "max-header-bytes", c.MaxHeaderBytes, "max request header size in bytes")
+	fs.IntVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "graceful shutdown timeout in seconds")
+	fs.IntVar(&c.TimelinePageLimit, "timeline-page-limit", c.TimelinePageLimit, "sweets per page")
+	fs.IntVar(&c.UserSearchPageLimit, "user-search-page-limit", c.UserSearchPageLimit, "users per page")
+	fs.StringVar(&c.TemplateDir, "template-dir", c.TemplateDir, "template directory")
+	fs.StringVar(&c.StaticDir, "static-dir", c.StaticDir, "static file directory")
+}
+
+// フラグ名に対応する環境変数名を返す
+func configEnvName(flagName string) string {
+	return ConfigEnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// 設定の読み出し
+// argsはプログラム名を除いたコマンドライン引数で, フラグ以外の残りの引数も返す
+// 設定ファイルは-configまたはSUITTER_CONFIGで指定し, 既定のパスになければ読み込まない
+func loadConfig(args []string) (*Config, []string, error) {
+	config := defaultConfig()
+	fs := flag.NewFlagSet("suitter", flag.ContinueOnError)
+	path := fs.String("config", DefaultConfigPath, "config file path")
+	config.bindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	// 指定されたフラグは最後に適用し直す
+	explicit := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	// 設定ファイル
+	p, ok := explicit["config"]
+	if ok == false {
+		p, ok = os.LookupEnv(configEnvName("config"))
+	}
+	if ok == false {
+		p = *path
+	}
 	bytes, err := ioutil.ReadFile(p)
-	if err != nil {
-		return nil, err
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(bytes, config); err != nil {
+			return nil, nil, errors.New(p + ": " + err.Error())
+		}
+	case os.IsNotExist(err) && ok == false:
+		// 既定のパスの設定ファイルは省略出来る
+	default:
+		return nil, nil, err
+	}
+
+	// 環境変数
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || envErr != nil {
+			return
+		}
+		if v, ok := os.LookupEnv(configEnvName(f.Name)); ok == true {
+			if err := f.Value.Set(v); err != nil {
+				envErr = errors.New(configEnvName(f.Name) + ": " + err.Error())
+			}
+		}
+	})
+	if envErr != nil {
+		return nil, nil, envErr
+	}
+
+	// コマンドラインフラグ
+	for name, v := range explicit {
+		if err := fs.Set(name, v); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return config, fs.Args(), nil
+}
+
+// Validate は設定の誤りをまとめたエラーを返す. 誤りがなければnilを返す
+func (c *Config) Validate() error {
+	var messages []string
+
+	// DB
+	switch c.DBDriver {
+	case "mysql", "postgres":
+		if c.DBUser == "" {
+			messages = append(messages, "DBUser is required for "+c.DBDriver)
+		}
+	case "sqlite3":
+		if c.DBHost != "" || c.DBPort != 0 || c.DBTLS != "" {
+			messages = append(messages, "DBHost, DBPort and DBTLS are not used for sqlite3")
+		}
+	default:
+		messages = append(messages, "DBDriver must be mysql, sqlite3 or postgres")
+	}
+	if c.DBName == "" {
+		messages = append(messages, "DBName is required")
+	}
+	if c.DBPort < 0 || c.DBPort > 65535 {
+		messages = append(messages, "DBPort must be between 0 and 65535")
+	}
+	switch c.DBTLS {
+	case "", "disable", "require", "verify":
+	default:
+		messages = append(messages, "DBTLS must be disable, require or verify")
 	}
-	var config Config
-	if err := json.Unmarshal(bytes, &config); err != nil {
-		return nil, err
+	if c.DBMaxOpenConns < 0 || c.DBMaxIdleConns < 0 || c.DBConnMaxLifetime < 0 {
+		messages = append(messages, "DBMaxOpenConns, DBMaxIdleConns and DBConnMaxLifetime must not be negative")
 	}
-	return &config, nil
+
+	// セッション
+	switch c.SessionStore {
+	case "memory", "db", "mysql":
+	case "file":
+		if c.SessionDir == "" {
+			messages = append(messages, "SessionDir is required for the file session store")
+		}
+	default:
+		messages = append(messages, "SessionStore must be memory, db or file")
+	}
+	if c.SessionCookieName == "" || strings.ContainsAny(c.SessionCookieName, " \t\r\n\"(),/:;<=>?@[\\]{}") {
+		messages = append(messages, "SessionCookieName must be a valid cookie name")
+	}
+	if c.SessionMaxAge <= 0 {
+		messages = append(messages, "SessionMaxAge must be positive")
+	}
+
+	// 検索
+	switch c.SearchBackend {
+	case "", "memory":
+	case "mysql":
+		if c.DBDriver != "mysql" {
+			messages = append(messages, "SearchBackend mysql requires DBDriver mysql")
+		}
+	default:
+		messages = append(messages, "SearchBackend must be mysql or memory")
+	}
+
+	// HTTP
+	if c.Listen == "" {
+		messages = append(messages, "Listen is required")
+	}
+	if c.ReadTimeout <= 0 || c.WriteTimeout <= 0 || c.IdleTimeout <= 0 || c.ShutdownTimeout <= 0 {
+		messages = append(messages, "ReadTimeout, WriteTimeout, IdleTimeout and ShutdownTimeout must be positive")
+	}
+	if c.MaxHeaderBytes <= 0 {
+		messages = append(messages, "MaxHeaderBytes must be positive")
+	}
+	if c.TimelinePageLimit <= 0 || c.UserSearchPageLimit <= 0 {
+		messages = append(messages, "TimelinePageLimit and UserSearchPageLimit must be positive")
+	}
+
+	if len(messages) > 0 {
+		return errors.New("invalid config: " + strings.Join(messages, ", "))
+	}
+	return nil
 }
 
-// DBConnection は*sql.DBを返すメソッド
-// 他のDBMSを使いたくなったときに複数個所の変更は面倒なので局所化した
-func DBConnection() (*sql.DB, error) {
-	conStr := fmt.Sprintf("%s:%s@/%s?parseTime=true",
-		applicationConfig.DBUser,
-		applicationConfig.DBPassword,
-		applicationConfig.DBName)
-	return sql.Open("mysql", conStr)
+// ValidateServeDirs はサーバで使うディレクトリが存在しなければエラーを返す
+// migrateなどのサブコマンドはソースの外でも動かせるよう, Validateでは確認しない
+func (c *Config) ValidateServeDirs() error {
+	var messages []string
+	for _, dir := range []string{c.TemplateDir, c.StaticDir} {
+		if info, err := os.Stat(dir); err != nil || info.IsDir() == false {
+			messages = append(messages, dir+" is not a directory")
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New("invalid config: " + strings.Join(messages, ", "))
+	}
+	return nil
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// テンプレートや静的ファイルのないディレクトリでもmigrateの設定は読み込める
+func TestLoadConfigWithoutServeDirs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"DBDriver": "sqlite3"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, args, err := loadConfig([]string{
+		"-config", path,
+		"-db-name", filepath.Join(dir, "suitter.db"),
+		"-template-dir", filepath.Join(dir, "templates"),
+		"-static-dir", filepath.Join(dir, "static"),
+		"migrate", "up",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(args, []string{"migrate", "up"}) == false {
+		t.Errorf("args = %q", args)
+	}
+	if err := config.ValidateServeDirs(); err == nil {
+		t.Error("ValidateServeDirs accepts missing directories")
+	}
+}
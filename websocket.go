@@ -0,0 +1,281 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// WebSocketWriteWait は1つのメッセージの送信を待つ時間
+	// これを超えて受け取れないクライアントは切断する
+	WebSocketWriteWait = 10 * time.Second
+	// WebSocketPongWait はpingへの応答を待つ時間
+	WebSocketPongWait = 60 * time.Second
+	// WebSocketPingInterval はpingを送る間隔. WebSocketPongWaitより短くする
+	WebSocketPingInterval = 50 * time.Second
+	// WebSocketMaxMessageSize はクライアントから受け取るメッセージの最大バイト数
+	WebSocketMaxMessageSize = 4096
+	// WebSocketMaxSubscriptions は1つの接続で購読出来るタグ, ユーザのそれぞれの最大数
+	WebSocketMaxSubscriptions = 50
+	// WebSocketReplyBuffer は送信待ちにしておける購読の応答の数
+	WebSocketReplyBuffer = 16
+)
+
+// WebSocketの接続に使うUpgrader
+// 既定のCheckOriginで他のサイトからの接続は拒否する
+var webSocketUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsClientMessage はクライアントから受け取るメッセージ
+// Typeがsubscribeならタグとユーザを購読し, unsubscribeなら購読をやめる
+type wsClientMessage struct {
+	Type  string   `json:"type"`
+	Tags  []string `json:"tags"`
+	Users []int64  `json:"users"`
+}
+
+// wsMessage はクライアントへ送るメッセージ
+type wsMessage struct {
+	Type         string           `json:"type"`
+	Sweet        *apiSweet        `json:"sweet,omitempty"`
+	UserID       int64            `json:"user_id,omitempty"`
+	TargetUserID int64            `json:"target_user_id,omitempty"`
+	PostID       int64            `json:"post_id,omitempty"`
+	Notification *apiNotification `json:"notification,omitempty"`
+	UnreadCount  *int64           `json:"unread_count,omitempty"`
+	Tags         []string         `json:"tags,omitempty"`
+	Users        []int64          `json:"users,omitempty"`
+	Messages     []string         `json:"messages,omitempty"`
+}
+
+// wsSubscriptions は1つの接続で購読しているタグとユーザ
+// 受信と送信のgoroutineから使う
+type wsSubscriptions struct {
+	tags  map[string]bool
+	users map[int64]bool
+	lock  sync.Mutex
+}
+
+// 購読の変更を行い, 変更後に購読しているタグとユーザを返す
+// 上限を超える場合は変更せずにメッセージを返す
+func (ws *wsSubscriptions) update(m *wsClientMessage) *wsMessage {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	switch m.Type {
+	case "subscribe":
+		if len(ws.tags)+len(m.Tags) > WebSocketMaxSubscriptions || len(ws.users)+len(m.Users) > WebSocketMaxSubscriptions {
+			return &wsMessage{Type: "error", Messages: []string{"購読出来るタグとユーザはそれぞれ50件までです"}}
+		}
+		for _, t := range m.Tags {
+			ws.tags[normalizeTag(t)] = true
+		}
+		for _, id := range m.Users {
+			ws.users[id] = true
+		}
+	case "unsubscribe":
+		for _, t := range m.Tags {
+			delete(ws.tags, normalizeTag(t))
+		}
+		for _, id := range m.Users {
+			delete(ws.users, id)
+		}
+	default:
+		return &wsMessage{Type: "error", Messages: []string{"typeはsubscribeまたはunsubscribeを指定してください"}}
+	}
+
+	res := &wsMessage{Type: "subscriptions", Tags: make([]string, 0, len(ws.tags)), Users: make([]int64, 0, len(ws.users))}
+	for t := range ws.tags {
+		res.Tags = append(res.Tags, t)
+	}
+	for id := range ws.users {
+		res.Users = append(res.Users, id)
+	}
+	return res
+}
+
+// pが購読しているタグを含むか, 購読しているユーザの投稿であればtrueを返す
+func (ws *wsSubscriptions) matches(p *Post) bool {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	if ws.users[p.UserID] == true {
+		return true
+	}
+	for _, t := range parseTags(p.Message) {
+		if ws.tags[t] == true {
+			return true
+		}
+	}
+	return false
+}
+
+// userIDへ送るsweetのイベントを作る. 送らないものはnilを返す
+// タイムラインに表示されるものと, 購読しているタグ, ユーザのもので閲覧出来るものを送る
+func (st *Store) wsSweetMessage(userID int64, e *HubEvent, subs *wsSubscriptions) (*wsMessage, error) {
+	p, exist, err := st.Posts.TimelineSweet(userID, e.PostID)
+	if err != nil {
+		return nil, err
+	}
+	if exist == false {
+		posts, err := st.Posts.FindByIDs(userID, []int64{e.PostID})
+		if err != nil {
+			return nil, err
+		}
+		if len(posts) == 0 || subs.matches(&posts[0]) == false {
+			return nil, nil
+		}
+		if err := st.fillLikedByMe(userID, posts); err != nil {
+			return nil, err
+		}
+		p = posts[0]
+	}
+	sweet := newAPISweet(p)
+	return &wsMessage{Type: HubEventSweet, Sweet: &sweet}, nil
+}
+
+// userIDへ送るイベントを作る. 送らないものはnilを返す
+func (st *Store) wsEventMessage(userID int64, e *HubEvent, subs *wsSubscriptions) (*wsMessage, error) {
+	switch e.Type {
+	case HubEventSweet:
+		return st.wsSweetMessage(userID, e, subs)
+	case HubEventLike, HubEventFollow:
+		// 自分の操作と自分への操作のみ送る
+		if e.UserID != userID && e.TargetUserID != userID {
+			return nil, nil
+		}
+		if e.UserID != userID {
+			// ミュート, ブロック関係にあるユーザからのものは送らない
+			hidden, err := st.isBlockedEither(userID, e.UserID)
+			if err != nil || hidden == true {
+				return nil, err
+			}
+			hidden, err = st.isMuting(userID, e.UserID)
+			if err != nil || hidden == true {
+				return nil, err
+			}
+		}
+		return &wsMessage{Type: e.Type, UserID: e.UserID, TargetUserID: e.TargetUserID, PostID: e.PostID}, nil
+	case HubEventNotification:
+		if e.TargetUserID != userID {
+			return nil, nil
+		}
+		n, exist, err := st.findNotification(userID, e.NotificationID)
+		if err != nil || exist == false {
+			return nil, err
+		}
+		unread, err := st.countUnreadNotifications(userID)
+		if err != nil {
+			return nil, err
+		}
+		notification := newAPINotifications([]Notification{n})[0]
+		return &wsMessage{Type: e.Type, Notification: &notification, UnreadCount: &unread}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// [/api/v1/ws]処理用のハンドラ
+// 新しいsweet, いいね, フォロー, 通知のイベントをWebSocketで送る
+// 受信は購読の変更のみを受け付け, 送信はこのgoroutineのみで行う
+func (app *App) apiWebSocketHandler(w http.ResponseWriter, r *http.Request, s *Session) {
+	// 認証したユーザのIDを取得
+	uid, err := sessionUserID(s)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, nil)
+		return
+	}
+	// 切り替えた接続はhttp.ServerのShutdownで待たれないので, 停止時に終わるのを待てるようにする
+	// 停止を始めた後の接続は切り替えない
+	if app.addWebSocket() == false {
+		writeJSONError(w, http.StatusServiceUnavailable, nil)
+		return
+	}
+	defer app.webSockets.Done()
+
+	// 接続の切り替え. 失敗した場合はUpgraderがエラーを返している
+	// 切り替え後はサーバのタイムアウトが外れるので, 送受信ごとに期限を設定する
+	conn, err := webSocketUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub := app.Hub.Subscribe()
+	defer sub.Unsubscribe()
+
+	// 受信
+	subs := &wsSubscriptions{tags: make(map[string]bool), users: make(map[int64]bool)}
+	replies := make(chan *wsMessage, WebSocketReplyBuffer)
+	done := make(chan struct{})
+	conn.SetReadLimit(WebSocketMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(WebSocketPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(WebSocketPongWait))
+	})
+	go func() {
+		defer close(done)
+		for {
+			var m wsClientMessage
+			if err := conn.ReadJSON(&m); err != nil {
+				// 形式の誤りも含めて切断する
+				return
+			}
+			select {
+			case replies <- subs.update(&m):
+			default:
+				// 応答を受け取らずに送り続けるクライアントは切断する
+				return
+			}
+		}
+	}()
+
+	// 送信
+	send := func(m *wsMessage) bool {
+		conn.SetWriteDeadline(time.Now().Add(WebSocketWriteWait))
+		return conn.WriteJSON(m) == nil
+	}
+	ping := time.NewTicker(WebSocketPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(WebSocketWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case m := <-replies:
+			if send(m) == false {
+				return
+			}
+		case e, ok := <-sub.C:
+			// 配信が追いつかずに打ち切られた場合やサーバの停止時は再接続してもらう
+			if ok == false {
+				closeMessage := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow")
+				if app.Hub.Closed() == true {
+					closeMessage = websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+				}
+				conn.SetWriteDeadline(time.Now().Add(WebSocketWriteWait))
+				conn.WriteMessage(websocket.CloseMessage, closeMessage)
+				return
+			}
+			m, err := app.wsEventMessage(uid, &e, subs)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			if m != nil && send(m) == false {
+				return
+			}
+		}
+	}
+}